@@ -0,0 +1,300 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Controller Command Line Tool
+
+An interactive tool for bringing up and testing a Maestro servo controller.
+
+Usage: scctl [-port /dev/ttyACM0] [-baud 115200] [-device 12] [-compact] [-crc] [-autobaud=false]
+
+Commands are read from stdin, one per line, e.g.
+
+	set 3 6000
+	pos 3
+	home
+	errors
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deadsy/maestro/sc"
+	"github.com/tarm/serial"
+)
+
+//-----------------------------------------------------------------------------
+
+const readTimeout = 500 * time.Millisecond
+
+// command is an interactive command.
+type command struct {
+	name  string                                                      // command name
+	args  string                                                      // argument usage
+	help  string                                                      // help string
+	nargs int                                                         // number of arguments
+	fn    func(w io.Writer, ctrl *sc.Controller, args []string) error // command function
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"set", "<channel> <target>", "set the servo target (0.25us units)", 2, cmdSet},
+		{"off", "<channel>", "disable the servo output", 1, cmdOff},
+		{"pos", "<channel>", "get the servo position (0.25us units)", 1, cmdPos},
+		{"speed", "<channel> <speed>", "set the servo speed limit (0 is no limit)", 2, cmdSpeed},
+		{"accel", "<channel> <acceleration>", "set the servo acceleration limit (0 is no limit)", 2, cmdAccel},
+		{"home", "", "send all servos to their home positions", 0, cmdHome},
+		{"moving", "", "show if any servos are moving", 0, cmdMoving},
+		{"errors", "", "get and clear the controller errors", 0, cmdErrors},
+		{"stop", "", "stop the script", 0, cmdStop},
+		{"help", "", "show the commands", 0, cmdHelp},
+	}
+}
+
+//-----------------------------------------------------------------------------
+// argument parsing
+
+// parseChannel parses a servo channel number.
+func parseChannel(s string) (uint8, error) {
+	x, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("bad channel \"%s\"", s)
+	}
+	return uint8(x), nil
+}
+
+// parseUint16 parses a 16-bit argument value.
+func parseUint16(s string) (uint16, error) {
+	x, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad value \"%s\"", s)
+	}
+	return uint16(x), nil
+}
+
+// parseServo parses a servo channel number and returns the servo.
+func parseServo(ctrl *sc.Controller, s string) (*sc.Servo, error) {
+	ch, err := parseChannel(s)
+	if err != nil {
+		return nil, err
+	}
+	return ctrl.NewServo(ch)
+}
+
+//-----------------------------------------------------------------------------
+// commands
+
+func cmdSet(w io.Writer, ctrl *sc.Controller, args []string) error {
+	s, err := parseServo(ctrl, args[0])
+	if err != nil {
+		return err
+	}
+	target, err := parseUint16(args[1])
+	if err != nil {
+		return err
+	}
+	return s.SetTarget(target)
+}
+
+func cmdOff(w io.Writer, ctrl *sc.Controller, args []string) error {
+	s, err := parseServo(ctrl, args[0])
+	if err != nil {
+		return err
+	}
+	return s.Disable()
+}
+
+func cmdPos(w io.Writer, ctrl *sc.Controller, args []string) error {
+	s, err := parseServo(ctrl, args[0])
+	if err != nil {
+		return err
+	}
+	pos, err := s.GetPosition()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d (%.2fus)\n", pos, float64(pos)/4)
+	return nil
+}
+
+func cmdSpeed(w io.Writer, ctrl *sc.Controller, args []string) error {
+	s, err := parseServo(ctrl, args[0])
+	if err != nil {
+		return err
+	}
+	speed, err := parseUint16(args[1])
+	if err != nil {
+		return err
+	}
+	return s.SetSpeed(sc.Speed(speed))
+}
+
+func cmdAccel(w io.Writer, ctrl *sc.Controller, args []string) error {
+	s, err := parseServo(ctrl, args[0])
+	if err != nil {
+		return err
+	}
+	accel, err := parseUint16(args[1])
+	if err != nil {
+		return err
+	}
+	return s.SetAcceleration(sc.Acceleration(accel))
+}
+
+func cmdHome(w io.Writer, ctrl *sc.Controller, args []string) error {
+	return ctrl.GoHome()
+}
+
+func cmdMoving(w io.Writer, ctrl *sc.Controller, args []string) error {
+	moving, err := ctrl.GetMovingState()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%t\n", moving)
+	return nil
+}
+
+func cmdErrors(w io.Writer, ctrl *sc.Controller, args []string) error {
+	_, err := ctrl.CheckErrors()
+	var cerr *sc.ControllerError
+	if errors.As(err, &cerr) {
+		fmt.Fprintf(w, "0x%04x %s\n", cerr.Bits, cerr)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "none\n")
+	return nil
+}
+
+func cmdStop(w io.Writer, ctrl *sc.Controller, args []string) error {
+	return ctrl.StopScript()
+}
+
+func cmdHelp(w io.Writer, ctrl *sc.Controller, args []string) error {
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "%-30s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+	}
+	fmt.Fprintf(w, "%-30s %s\n", "quit", "exit the tool")
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// dispatch runs a command line, writing any command output to w.
+func dispatch(w io.Writer, ctrl *sc.Controller, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	for _, cmd := range commands {
+		if cmd.name != fields[0] {
+			continue
+		}
+		args := fields[1:]
+		if len(args) != cmd.nargs {
+			return fmt.Errorf("usage: %s", strings.TrimSpace(cmd.name+" "+cmd.args))
+		}
+		return cmd.fn(w, ctrl, args)
+	}
+	return fmt.Errorf("unknown command \"%s\" (try help)", fields[0])
+}
+
+// repl reads commands from r and runs them until quit or end of input.
+func repl(ctrl *sc.Controller, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprintf(w, "scctl> ")
+		if !scanner.Scan() {
+			fmt.Fprintf(w, "\n")
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		err := dispatch(w, ctrl, line)
+		if err != nil {
+			fmt.Fprintf(w, "error: %s\n", err)
+		}
+	}
+}
+
+func scctl() error {
+
+	portName := flag.String("port", "/dev/ttyACM0", "serial port")
+	baud := flag.Int("baud", 115200, "baud rate")
+	device := flag.Uint("device", 12, "device number")
+	compact := flag.Bool("compact", false, "use the compact protocol")
+	crc := flag.Bool("crc", false, "add a crc byte to commands")
+	autobaud := flag.Bool("autobaud", true, "send 0xaa for auto baud detection (UART detect baud rate mode)")
+	flag.Parse()
+
+	if *device > 127 {
+		return fmt.Errorf("bad device number %d", *device)
+	}
+
+	// tarm/serial has no read deadlines, so set the timeout on the port.
+	serialConfig := &serial.Config{
+		Name:        *portName,
+		Baud:        *baud,
+		ReadTimeout: readTimeout,
+	}
+
+	port, err := serial.OpenPort(serialConfig)
+	if err != nil {
+		return err
+	}
+
+	dev := uint8(*device)
+	if *compact {
+		dev = 0
+	}
+
+	initAction := sc.InitNone
+	if *autobaud {
+		initAction = sc.InitAutoBaud
+	}
+
+	scConfig := &sc.Config{
+		Port:         port,
+		DeviceNumber: dev,
+		Compact:      *compact,
+		Crc:          *crc,
+		ReadTimeout:  readTimeout,
+		InitAction:   initAction,
+	}
+
+	ctrl, err := sc.NewController(scConfig)
+	if err != nil {
+		port.Close()
+		return err
+	}
+	defer ctrl.Close()
+
+	return repl(ctrl, os.Stdin, os.Stdout)
+}
+
+func main() {
+	err := scctl()
+	if err != nil {
+		log.Fatalf("error: %s", err)
+	}
+}
+
+//-----------------------------------------------------------------------------
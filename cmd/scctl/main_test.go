@@ -0,0 +1,107 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Controller Command Line Tool
+
+*/
+//-----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/deadsy/maestro/sc"
+	"github.com/deadsy/maestro/sc/sctest"
+)
+
+//-----------------------------------------------------------------------------
+
+// newTestController returns a compact protocol controller on a recording port.
+func newTestController(t *testing.T) (*sc.Controller, *sctest.Port) {
+	port := sctest.NewPort()
+	ctrl, err := sc.NewController(&sc.Config{Port: port, Compact: true, InitAction: sc.InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctrl, port
+}
+
+func TestDispatch(t *testing.T) {
+	ctrl, port := newTestController(t)
+	tests := []struct {
+		line  string // command line
+		rsp   []byte // queued response
+		frame []byte // expected frame (nil for none)
+		out   string // expected output
+	}{
+		{"set 3 6000", nil, []byte{0x84, 3, 0x70, 0x2e}, ""},
+		{"pos 3", []byte{0x70, 0x17}, []byte{0x90, 3}, "6000 (1500.00us)\n"},
+		{"speed 3 20", nil, []byte{0x87, 3, 20, 0}, ""},
+		{"accel 3 0x100", nil, []byte{0x89, 3, 0, 2}, ""},
+		{"off 3", nil, []byte{0x84, 3, 0, 0}, ""},
+		{"home", nil, []byte{0xa2}, ""},
+		{"stop", nil, []byte{0xa4}, ""},
+		{"moving", []byte{0x01}, []byte{0x93}, "true\n"},
+		{"errors", []byte{0x10, 0x00}, []byte{0xa1}, "0x0010 serial protocol error\n"},
+		{"errors", []byte{0x00, 0x00}, []byte{0xa1}, "none\n"},
+		{"  ", nil, nil, ""},
+	}
+	for _, v := range tests {
+		port.QueueResponse(v.rsp...)
+		var out bytes.Buffer
+		err := dispatch(&out, ctrl, v.line)
+		if err != nil {
+			t.Errorf("%q: %s", v.line, err)
+			continue
+		}
+		if v.frame != nil {
+			port.AssertFrame(t, v.frame)
+		}
+		port.AssertNoFrames(t)
+		if out.String() != v.out {
+			t.Errorf("%q: output %q, expected %q", v.line, out.String(), v.out)
+		}
+	}
+}
+
+func TestDispatchErrors(t *testing.T) {
+	ctrl, port := newTestController(t)
+	tests := []struct {
+		line string // command line
+		err  string // expected error
+	}{
+		{"bogus", "unknown command \"bogus\" (try help)"},
+		{"set 3", "usage: set <channel> <target>"},
+		{"home 1", "usage: home"},
+		{"set x 6000", "bad channel \"x\""},
+		{"set 3 70000", "bad value \"70000\""},
+		{"pos 24", "bad servo channel 24"},
+	}
+	for _, v := range tests {
+		err := dispatch(&bytes.Buffer{}, ctrl, v.line)
+		if err == nil || err.Error() != v.err {
+			t.Errorf("%q: expected error %q, got %v", v.line, v.err, err)
+		}
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestRepl(t *testing.T) {
+	ctrl, port := newTestController(t)
+	var out bytes.Buffer
+	err := repl(ctrl, strings.NewReader("set 3 6000\nbogus\nquit\nset 3 7000\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commands after quit are not run
+	port.AssertFrame(t, []byte{0x84, 3, 0x70, 0x2e})
+	port.AssertNoFrames(t)
+	if !strings.Contains(out.String(), "error: unknown command \"bogus\"") {
+		t.Errorf("missing error in output %q", out.String())
+	}
+}
+
+//-----------------------------------------------------------------------------
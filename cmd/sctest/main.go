@@ -9,6 +9,7 @@ Servo Controller Test
 package main
 
 import (
+	"errors"
 	"log"
 	"time"
 
@@ -20,38 +21,43 @@ import (
 
 func sctest() error {
 
+	const readTimeout = 500 * time.Millisecond
+
+	// The maestro detects the baud rate, any standard rate will do.
+	// tarm/serial has no read deadlines, so set the timeout on the port.
 	serialConfig := &serial.Config{
 		Name:        "/dev/ttyACM0",
 		Baud:        115200,
-		ReadTimeout: time.Millisecond * 500,
+		ReadTimeout: readTimeout,
 	}
 
 	port, err := serial.OpenPort(serialConfig)
 	if err != nil {
 		return err
 	}
-	defer port.Close()
 
 	scConfig := &sc.Config{
 		Port:         port,
 		DeviceNumber: 12,
 		Compact:      false,
 		Crc:          true,
+		ReadTimeout:  readTimeout,
 	}
 
 	ctrl, err := sc.NewController(scConfig)
 	if err != nil {
+		port.Close()
 		return err
 	}
+	defer ctrl.Close()
 
 	// get/clear any initial error code
-	code, err := ctrl.GetErrors()
-	if err != nil {
-		return err
-	}
-	err = sc.GetError(code)
-	if err != nil {
+	_, err = ctrl.CheckErrors()
+	var cerr *sc.ControllerError
+	if errors.As(err, &cerr) {
 		log.Printf("controller error: %s", err)
+	} else if err != nil {
+		return err
 	}
 
 	s0, _ := ctrl.NewServo(0)
@@ -0,0 +1,107 @@
+//-----------------------------------------------------------------------------
+/*
+
+Serial Bus
+
+Multiple Maestro controllers (with different device numbers) can be daisy
+chained on a single serial bus using the Pololu protocol. Controllers on a
+bus share a lock so that each command or query (write and response read)
+completes before another is started.
+
+The Pololu protocol has no broadcast device number, so commands for all
+controllers on a bus are sent to each known device in turn.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------
+
+// Bus is a serial bus shared by multiple controllers.
+type Bus struct {
+	mu   sync.Mutex    // serializes port access
+	port io.ReadWriter // serial port
+	ctrl []*Controller // controllers on the bus
+}
+
+// NewBus returns a new serial bus using the port.
+func NewBus(port io.ReadWriter) *Bus {
+	return &Bus{
+		port: port,
+	}
+}
+
+// NewController returns a new servo controller on the bus.
+// The configuration port is set to the bus port.
+func (b *Bus) NewController(cfg *Config) (*Controller, error) {
+	if cfg.Compact {
+		return nil, errors.New("the compact protocol can't address multiple devices")
+	}
+	for _, c := range b.ctrl {
+		if c.device == cfg.DeviceNumber {
+			return nil, errors.New("device number is already on the bus")
+		}
+	}
+	x := *cfg
+	x.Port = b.port
+	x.Transport = nil
+	c, err := newController(&x, &b.mu)
+	if err != nil {
+		return nil, err
+	}
+	b.ctrl = append(b.ctrl, c)
+	return c, nil
+}
+
+// Controllers returns the controllers on the bus.
+func (b *Bus) Controllers() []*Controller {
+	return append([]*Controller(nil), b.ctrl...)
+}
+
+// AnyMoving returns true if any controller on the bus has not reached the target value for all servos.
+func (b *Bus) AnyMoving() (bool, error) {
+	for _, c := range b.ctrl {
+		moving, err := c.GetMovingState()
+		if err != nil {
+			return false, err
+		}
+		if moving {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Broadcast calls a function for each controller on the bus (in the order they were added).
+// A failure doesn't stop the function being called for the remaining controllers,
+// and the first error is returned.
+func (b *Bus) Broadcast(fn func(c *Controller) error) error {
+	var first error
+	for _, c := range b.ctrl {
+		err := fn(c)
+		if err != nil && first == nil {
+			first = fmt.Errorf("device %d: %w", c.device, err)
+		}
+	}
+	return first
+}
+
+// GoHome sends all servos on all controllers on the bus to their home positions.
+func (b *Bus) GoHome() error {
+	return b.Broadcast((*Controller).GoHome)
+}
+
+// StopScript stops the scripts on all controllers on the bus.
+func (b *Bus) StopScript() error {
+	return b.Broadcast((*Controller).StopScript)
+}
+
+//-----------------------------------------------------------------------------
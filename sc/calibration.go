@@ -0,0 +1,314 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Calibration
+
+A calibration maps a physical range (e.g. degrees) onto a servo target range.
+The degree and percent conversions use the calibration of the servo.
+
+Calibrate finds the mechanical endpoints of a servo and sets its limits.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// Calibration maps a physical range onto a servo target range.
+type Calibration struct {
+	MinTarget   uint16  `json:"min_target"`   // target value at the minimum physical value
+	MaxTarget   uint16  `json:"max_target"`   // target value at the maximum physical value
+	MinPhysical float64 `json:"min_physical"` // minimum physical value (e.g. degrees)
+	MaxPhysical float64 `json:"max_physical"` // maximum physical value (e.g. degrees)
+	Reversed    bool    `json:"reversed"`     // the minimum physical value is at MaxTarget
+}
+
+// validate checks the calibration.
+func (cal *Calibration) validate() error {
+	if cal.MinTarget >= cal.MaxTarget {
+		return errors.New("min target >= max target")
+	}
+	if cal.MaxTarget > maxTarget {
+		return fmt.Errorf("max target > %d", maxTarget)
+	}
+	if cal.MinPhysical >= cal.MaxPhysical {
+		return errors.New("min physical >= max physical")
+	}
+	return nil
+}
+
+// fraction converts a physical value to a 0..1 fraction of the target range.
+func (cal *Calibration) fraction(x float64) float64 {
+	f := (x - cal.MinPhysical) / (cal.MaxPhysical - cal.MinPhysical)
+	if cal.Reversed {
+		f = 1 - f
+	}
+	return f
+}
+
+// physical converts a 0..1 fraction of the target range to a physical value.
+func (cal *Calibration) physical(f float64) float64 {
+	if cal.Reversed {
+		f = 1 - f
+	}
+	return cal.MinPhysical + f*(cal.MaxPhysical-cal.MinPhysical)
+}
+
+// toTarget converts a 0..1 fraction to a real valued target.
+func (cal *Calibration) toTarget(f float64) float64 {
+	return float64(cal.MinTarget) + f*float64(cal.MaxTarget-cal.MinTarget)
+}
+
+// toFraction converts a target to a 0..1 fraction of the target range.
+func (cal *Calibration) toFraction(target uint16) float64 {
+	return (float64(target) - float64(cal.MinTarget)) / float64(cal.MaxTarget-cal.MinTarget)
+}
+
+//-----------------------------------------------------------------------------
+
+// SetCalibration sets the servo calibration (nil removes the calibration).
+func (s *Servo) SetCalibration(cal *Calibration) error {
+	if cal == nil {
+		s.cal = nil
+		return nil
+	}
+	err := cal.validate()
+	if err != nil {
+		return err
+	}
+	x := *cal
+	s.cal = &x
+	return nil
+}
+
+// Calibration returns a copy of the servo calibration (nil if there is none).
+func (s *Servo) Calibration() *Calibration {
+	if s.cal == nil {
+		return nil
+	}
+	x := *s.cal
+	return &x
+}
+
+// percentCal returns the calibration used for percent conversions.
+// Without a calibration the servo min..max range is used.
+func (s *Servo) percentCal() *Calibration {
+	if s.cal != nil {
+		return s.cal
+	}
+	return &Calibration{
+		MinTarget:   s.min,
+		MaxTarget:   s.max,
+		MinPhysical: 0,
+		MaxPhysical: 100,
+	}
+}
+
+// DegreesToTarget converts an angle in degrees to a target value using the servo calibration.
+func (s *Servo) DegreesToTarget(deg float64) (uint16, error) {
+	if s.cal == nil {
+		return 0, fmt.Errorf("channel %d: no calibration", s.channel)
+	}
+	return s.rounding.toTicks(s.cal.toTarget(s.cal.fraction(deg))), nil
+}
+
+// TargetToDegrees converts a target value to an angle in degrees using the servo calibration.
+func (s *Servo) TargetToDegrees(target uint16) (float64, error) {
+	if s.cal == nil {
+		return 0, fmt.Errorf("channel %d: no calibration", s.channel)
+	}
+	return s.cal.physical(s.cal.toFraction(target)), nil
+}
+
+// SetTargetDegrees sets the servo target as an angle in degrees.
+func (s *Servo) SetTargetDegrees(deg float64) error {
+	target, err := s.DegreesToTarget(deg)
+	if err != nil {
+		return err
+	}
+	return s.SetTarget(target)
+}
+
+// SetTargetDegreesWrap sets the servo target as an angle in degrees for a servo that can
+// turn through more than 180 degrees (e.g. a pan or turntable servo). The calibration
+// should cover the full mechanical range of the servo. Angles that differ by whole turns
+// (360 degrees) are equivalent, and the equivalent angle within the calibrated range that
+// is closest to the last target is used (the shortest path). Angles with no equivalent in
+// the calibrated range are clamped to the range.
+func (s *Servo) SetTargetDegreesWrap(deg float64) error {
+	if s.cal == nil {
+		return fmt.Errorf("channel %d: no calibration", s.channel)
+	}
+	lo, hi := s.cal.MinPhysical, s.cal.MaxPhysical
+	// current angle
+	cur := lo
+	if s.sent && s.target != 0 {
+		cur, _ = s.TargetToDegrees(s.target)
+	}
+	// the first equivalent angle >= lo
+	x := lo + math.Mod(math.Mod(deg-lo, 360)+360, 360)
+	if x > hi {
+		// no equivalent angle in range, clamp to the nearest end of the range
+		if x-hi < lo+360-x {
+			x = hi
+		} else {
+			x = lo
+		}
+	} else {
+		// the equivalent angle in range closest to the current angle
+		best := x
+		for a := x; a <= hi; a += 360 {
+			if math.Abs(a-cur) < math.Abs(best-cur) {
+				best = a
+			}
+		}
+		x = best
+	}
+	return s.SetTargetDegrees(x)
+}
+
+// SetSpeedDegPerSec sets the servo speed limit in degrees/second using the servo calibration (0 is no limit).
+func (s *Servo) SetSpeedDegPerSec(dps float64) error {
+	if s.cal == nil {
+		return fmt.Errorf("channel %d: no calibration", s.channel)
+	}
+	if dps < 0 {
+		return fmt.Errorf("channel %d: negative speed", s.channel)
+	}
+	// pulse width per degree
+	usPerDeg := float64(s.cal.MaxTarget-s.cal.MinTarget) / uSec / (s.cal.MaxPhysical - s.cal.MinPhysical)
+	speed := SpeedFromMicrosPerSec(dps * usPerDeg)
+	if speed == 0 && dps != 0 {
+		// don't round a slow speed to no limit
+		speed = 1
+	}
+	return s.SetSpeed(speed)
+}
+
+// PercentToTarget converts a percentage (0..100) of the calibrated
+// range (or of the servo min..max range) to a target value.
+func (s *Servo) PercentToTarget(pct float64) uint16 {
+	cal := s.percentCal()
+	f := pct / 100
+	if cal.Reversed {
+		f = 1 - f
+	}
+	return s.rounding.toTicks(cal.toTarget(f))
+}
+
+// SetTargetPercent sets the servo target as a percentage (0..100) of its range.
+func (s *Servo) SetTargetPercent(pct float64) error {
+	return s.SetTarget(s.PercentToTarget(pct))
+}
+
+// GetPercent returns the last target value sent as a percentage (0..100) of the servo range.
+func (s *Servo) GetPercent() (float64, error) {
+	if !s.sent {
+		return 0, fmt.Errorf("channel %d: no target has been sent", s.channel)
+	}
+	cal := s.percentCal()
+	f := cal.toFraction(s.target)
+	if cal.Reversed {
+		f = 1 - f
+	}
+	return 100 * f, nil
+}
+
+// PositionFraction returns the current position as a fraction (0..1) of the servo min..max range.
+// Positions outside the range are limited to 0 or 1.
+func (s *Servo) PositionFraction() (float64, error) {
+	if s.max == s.min {
+		return 0, fmt.Errorf("channel %d: zero servo range", s.channel)
+	}
+	pos, err := s.GetPosition()
+	if err != nil {
+		return 0, err
+	}
+	f := (float64(pos) - float64(s.min)) / float64(s.max-s.min)
+	if f < 0 {
+		return 0, nil
+	}
+	if f > 1 {
+		return 1, nil
+	}
+	return f, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Calibrate finds the mechanical endpoints of the servo and sets the servo limits to them.
+// The target is walked outward from the center of the current limits in steps, first down and
+// then up, waiting for the settle time after each step. The position is then read and passed
+// to the detect function, which returns true if the servo has stalled (e.g. using a current
+// sensor or a comparison with a position sensor). The endpoint is the last target before the
+// stall. The walk stops at the current limits, so set wide limits before calibrating. The servo
+// is returned to the center when done. If the context is canceled the limits are not changed.
+func (s *Servo) Calibrate(ctx context.Context, step uint16, settle time.Duration, detect func(pos uint16) bool) error {
+	if step == 0 {
+		return errors.New("step must be > 0")
+	}
+	center := s.center()
+	// move to a target and settle
+	moveTo := func(target uint16) error {
+		err := s.writeTarget(target)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(settle):
+		}
+		return nil
+	}
+	// walk from the center to an endpoint
+	walk := func(dir int) (uint16, error) {
+		err := moveTo(center)
+		if err != nil {
+			return 0, err
+		}
+		t := int(center)
+		for {
+			next := t + dir*int(step)
+			if next < int(s.min) || next > int(s.max) {
+				return uint16(t), nil
+			}
+			err := moveTo(uint16(next))
+			if err != nil {
+				return 0, err
+			}
+			pos, err := s.GetPosition()
+			if err != nil {
+				return 0, err
+			}
+			if detect(pos) {
+				return uint16(t), nil
+			}
+			t = next
+		}
+	}
+	lo, err := walk(-1)
+	if err != nil {
+		return err
+	}
+	hi, err := walk(1)
+	if err != nil {
+		return err
+	}
+	err = s.writeTarget(center)
+	if err != nil {
+		return err
+	}
+	return s.SetLimits(lo, hi)
+}
+
+//-----------------------------------------------------------------------------
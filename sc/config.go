@@ -0,0 +1,90 @@
+//-----------------------------------------------------------------------------
+/*
+
+Controller Configuration Builder
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// maximum device number (7 bits)
+const maxDevice = 0x7f
+
+// ConfigBuilder builds a controller configuration.
+type ConfigBuilder struct {
+	cfg Config
+}
+
+// NewConfig returns a configuration builder for the serial port
+// (nil if a transport is set with WithTransport).
+func NewConfig(port io.ReadWriter) *ConfigBuilder {
+	return &ConfigBuilder{
+		cfg: Config{
+			Port: port,
+		},
+	}
+}
+
+// WithTransport sets the serial transport (used instead of the port).
+func (b *ConfigBuilder) WithTransport(tr Transport) *ConfigBuilder {
+	b.cfg.Transport = tr
+	return b
+}
+
+// WithDevice sets the device number.
+func (b *ConfigBuilder) WithDevice(n uint8) *ConfigBuilder {
+	b.cfg.DeviceNumber = n
+	return b
+}
+
+// Compact selects the compact protocol (single device on serial bus).
+func (b *ConfigBuilder) Compact() *ConfigBuilder {
+	b.cfg.Compact = true
+	return b
+}
+
+// WithCRC adds a crc byte to outgoing commands.
+func (b *ConfigBuilder) WithCRC() *ConfigBuilder {
+	b.cfg.CrcWrite = true
+	return b
+}
+
+// WithResponseCRC checks a crc byte after each response.
+func (b *ConfigBuilder) WithResponseCRC() *ConfigBuilder {
+	b.cfg.CrcRead = true
+	return b
+}
+
+// WithReadTimeout sets the response read timeout.
+func (b *ConfigBuilder) WithReadTimeout(d time.Duration) *ConfigBuilder {
+	b.cfg.ReadTimeout = d
+	return b
+}
+
+// Build validates and returns the configuration.
+// A device number can't be set for the compact protocol.
+func (b *ConfigBuilder) Build() (*Config, error) {
+	if b.cfg.Port == nil && b.cfg.Transport == nil {
+		return nil, ErrNoPort
+	}
+	if b.cfg.DeviceNumber > maxDevice {
+		return nil, fmt.Errorf("device number > %d", maxDevice)
+	}
+	if b.cfg.Compact && b.cfg.DeviceNumber != 0 {
+		return nil, errors.New("device number is not used by the compact protocol")
+	}
+	cfg := b.cfg
+	return &cfg, nil
+}
+
+//-----------------------------------------------------------------------------
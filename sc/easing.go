@@ -0,0 +1,52 @@
+//-----------------------------------------------------------------------------
+/*
+
+Easing Functions
+
+An easing function maps the elapsed fraction of a host-side move (0..1) to
+the fraction of the distance moved (0..1).
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+// Easing maps the elapsed fraction of a move to the fraction of the distance moved.
+type Easing func(t float64) float64
+
+// Linear moves at a constant speed.
+func Linear(t float64) float64 {
+	return t
+}
+
+// EaseIn accelerates from the start of the move.
+func EaseIn(t float64) float64 {
+	return t * t
+}
+
+// EaseOut decelerates to the end of the move.
+func EaseOut(t float64) float64 {
+	return t * (2 - t)
+}
+
+// EaseInOut accelerates from the start and decelerates to the end of the move.
+func EaseInOut(t float64) float64 {
+	if t < 0.5 {
+		return 2 * t * t
+	}
+	return -1 + (4-2*t)*t
+}
+
+// ease returns the value at elapsed fraction t between from and to.
+func ease(from, to uint16, t float64, fn Easing) uint16 {
+	if fn == nil {
+		fn = Linear
+	}
+	return uint16(math.Round(float64(from) + (float64(to)-float64(from))*fn(t)))
+}
+
+//-----------------------------------------------------------------------------
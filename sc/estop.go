@@ -0,0 +1,62 @@
+//-----------------------------------------------------------------------------
+/*
+
+Emergency Stop
+
+Once the emergency stop has been triggered, commands that move the servos
+return ErrEStopped without writing to the serial port until it is cleared.
+Turning servos off (Disable) is still allowed.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+//-----------------------------------------------------------------------------
+
+// ErrEStopped is returned by servo motion commands while the emergency stop is triggered.
+var ErrEStopped = errors.New("emergency stopped")
+
+// SetEStop sets a function that is run when the emergency stop is triggered
+// (e.g. to turn off the servos). Set it before the controller is in use.
+func (c *Controller) SetEStop(fn func() error) {
+	c.estopFn = fn
+}
+
+// TriggerEStop triggers the emergency stop. The emergency stop function is run
+// (once per trigger) and its error is returned.
+func (c *Controller) TriggerEStop() error {
+	if !atomic.CompareAndSwapInt32(&c.estop, 0, 1) {
+		// already triggered
+		return nil
+	}
+	if c.estopFn != nil {
+		return c.estopFn()
+	}
+	return nil
+}
+
+// ClearEStop clears the emergency stop.
+func (c *Controller) ClearEStop() {
+	atomic.StoreInt32(&c.estop, 0)
+}
+
+// EStopped returns true if the emergency stop is triggered.
+func (c *Controller) EStopped() bool {
+	return atomic.LoadInt32(&c.estop) != 0
+}
+
+// checkEStop returns ErrEStopped if the emergency stop is triggered.
+func (c *Controller) checkEStop() error {
+	if c.EStopped() {
+		return ErrEStopped
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,350 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Frames
+
+A frame is a set of servo positions/targets keyed by channel number.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// Frame is a set of servo positions/targets keyed by channel number.
+type Frame map[uint8]uint16
+
+// RecordPositions samples the position of the listed servos at a fixed interval.
+// Sampling continues until the context is canceled, at which point the captured
+// frames are returned. A read error stops the recording and returns the frames
+// captured so far with the error. The logical positions (without the servo trim) are
+// recorded so that the frames can be played back as targets.
+func (c *Controller) RecordPositions(ctx context.Context, servos []*Servo, interval time.Duration) ([]Frame, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be > 0")
+	}
+	frames := []Frame{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f := make(Frame, len(servos))
+		for _, s := range servos {
+			pos, err := s.GetLogicalPosition()
+			if err != nil {
+				return frames, err
+			}
+			f[s.channel] = pos
+		}
+		frames = append(frames, f)
+		select {
+		case <-ctx.Done():
+			return frames, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// StreamFrames sets the servo targets for each frame received on the frames channel.
+// Each contiguous run of channels in a frame is sent with a SetTargets command built
+// in the preallocated command buffer. When the frames channel is closed nil is sent on
+// the returned channel. The first error is sent on the returned channel, and no more
+// frames are written, but the frames channel is still read (and the frames discarded)
+// until it is closed so that the sender doesn't block.
+func (c *Controller) StreamFrames(frames <-chan Frame) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		for f := range frames {
+			err := c.writeFrame(f)
+			if err != nil {
+				done <- err
+				for range frames {
+				}
+				return
+			}
+		}
+		done <- nil
+	}()
+	return done
+}
+
+// validateFrame checks all the channels and targets of a frame, so that
+// an invalid frame is rejected before any of it is written.
+func (c *Controller) validateFrame(f Frame) error {
+	err := c.checkEStop()
+	if err != nil {
+		return err
+	}
+	for ch := 0; ch <= 0xff; ch++ {
+		v, ok := f[uint8(ch)]
+		if !ok {
+			continue
+		}
+		if ch >= c.channels || c.servo[ch] == nil {
+			return fmt.Errorf("bad servo channel %d in frame", ch)
+		}
+		err := c.checkPWM(uint8(ch))
+		if err != nil {
+			return err
+		}
+		s := c.servo[ch]
+		if !s.clamp {
+			// (clamped targets are always valid)
+			_, err := s.checkTarget(v)
+			if err != nil {
+				return fmt.Errorf("%s for channel %d", err.Error(), ch)
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame writes the targets of a frame (the whole frame is checked before any are written).
+func (c *Controller) writeFrame(f Frame) error {
+	err := c.validateFrame(f)
+	if err != nil {
+		return err
+	}
+	for ch := 0; ch < maxServos; {
+		if _, ok := f[uint8(ch)]; !ok {
+			ch++
+			continue
+		}
+		// find the contiguous run
+		start := ch
+		for ch < maxServos {
+			if _, ok := f[uint8(ch)]; !ok {
+				break
+			}
+			ch++
+		}
+		err := c.writeRun(f, uint8(start), uint8(ch-start))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRun writes the targets for a contiguous run of frame channels.
+func (c *Controller) writeRun(f Frame, channel, n uint8) error {
+	var targets [maxServos]uint16
+	for i := uint8(0); i < n; i++ {
+		targets[i] = f[channel+i]
+	}
+	return c.setTargets(channel, targets[:n], true)
+}
+
+// FrameWriter sets the targets for a fixed bank of servos (a contiguous run of channels).
+// The SetTargets command arguments are built once, and only the target values are
+// updated for each frame.
+type FrameWriter struct {
+	ctrl   *Controller
+	servos []*Servo // servos in the bank
+	args   []byte   // SetTargets command arguments
+	vals   []uint16 // checked target values
+}
+
+// NewFrameWriter returns a frame writer for n servos starting at a channel.
+func (c *Controller) NewFrameWriter(channel uint8, n int) (*FrameWriter, error) {
+	if n < 1 || int(channel)+n > c.channels {
+		return nil, fmt.Errorf("bad channel range %d..%d", channel, int(channel)+n-1)
+	}
+	w := &FrameWriter{
+		ctrl:   c,
+		servos: make([]*Servo, n),
+		args:   make([]byte, 2+2*n),
+		vals:   make([]uint16, n),
+	}
+	for i := range w.servos {
+		s := c.servo[int(channel)+i]
+		if s == nil {
+			return nil, fmt.Errorf("bad servo channel %d", int(channel)+i)
+		}
+		w.servos[i] = s
+	}
+	w.args[0] = byte(n)
+	w.args[1] = channel
+	return w, nil
+}
+
+// Write sets the targets for the servos in the bank.
+func (w *FrameWriter) Write(targets []uint16) error {
+	if len(targets) != len(w.servos) {
+		return fmt.Errorf("%d targets for %d servos", len(targets), len(w.servos))
+	}
+	err := w.ctrl.checkEStop()
+	if err != nil {
+		return err
+	}
+	for i, s := range w.servos {
+		err := w.ctrl.checkPWM(s.channel)
+		if err != nil {
+			return err
+		}
+		val, err := s.checkTarget(targets[i])
+		if err != nil {
+			return fmt.Errorf("%s for channel %d", err.Error(), s.channel)
+		}
+		x, err := pack14(s.trimmed(val))
+		if err != nil {
+			return fmt.Errorf("%s for channel %d", err.Error(), s.channel)
+		}
+		w.vals[i] = val
+		w.args[2+2*i] = x[0]
+		w.args[3+2*i] = x[1]
+	}
+	for _, s := range w.servos {
+		s.stopSlew()
+	}
+	err = w.ctrl.cmdWriteArgs(cmdSetMultipleTargets, w.args...)
+	if !commandSent(err) {
+		return err
+	}
+	for i, s := range w.servos {
+		s.setSent(w.vals[i])
+	}
+	return err
+}
+
+// DeviceTarget is a target for a servo or jrk motor controller on a shared serial bus.
+type DeviceTarget struct {
+	Device  uint8  // device number
+	Jrk     bool   // jrk motor controller (Channel is not used)
+	Channel uint8  // servo channel number
+	Target  uint16 // target value
+}
+
+// deviceWrite is a servo target write made by SetDeviceTargets.
+type deviceWrite struct {
+	s   *Servo // servo
+	val uint16 // checked target value
+}
+
+// deviceCmds checks a frame of device targets and returns the commands for them.
+// The lookup function returns the controller for a servo device number (nil if unknown).
+// For a known controller the e-stop and PWM state are checked, and the servo limits
+// and trim are applied for its configured servos.
+func (l *link) deviceCmds(targets []DeviceTarget, lookup func(device uint8) (*Controller, error)) ([][]byte, []deviceWrite, error) {
+	if l.compact {
+		return nil, nil, errors.New("the compact protocol can't address multiple devices")
+	}
+	cmds := make([][]byte, len(targets))
+	writes := []deviceWrite{}
+	for i, t := range targets {
+		if t.Device > maxDevice {
+			return nil, nil, fmt.Errorf("bad device number %d", t.Device)
+		}
+		if t.Jrk {
+			cmd, err := l.jrkTarget(t.Device, t.Target)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s for device %d", err.Error(), t.Device)
+			}
+			cmds[i] = cmd
+			continue
+		}
+		if t.Channel >= maxServos {
+			return nil, nil, fmt.Errorf("bad servo channel %d for device %d", t.Channel, t.Device)
+		}
+		target := t.Target
+		c, err := lookup(t.Device)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c != nil {
+			if target != 0 {
+				err := c.checkEStop()
+				if err != nil {
+					return nil, nil, fmt.Errorf("device %d: %w", t.Device, err)
+				}
+			}
+			err := c.checkPWM(t.Channel)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s for device %d", err.Error(), t.Device)
+			}
+			if s := c.servo[t.Channel]; s != nil {
+				val, err := s.checkTarget(target)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s for device %d channel %d", err.Error(), t.Device, t.Channel)
+				}
+				writes = append(writes, deviceWrite{s, val})
+				target = s.trimmed(val)
+			}
+		}
+		x, err := pack14(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s for device %d channel %d", err.Error(), t.Device, t.Channel)
+		}
+		cmds[i] = append(l.devicePreamble(t.Device, cmdSetTarget), t.Channel, x[0], x[1])
+	}
+	return cmds, writes, nil
+}
+
+// writeDeviceTargets writes the device target commands and records the servo targets sent.
+func (l *link) writeDeviceTargets(cmds [][]byte, writes []deviceWrite) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	for _, w := range writes {
+		w.s.stopSlew()
+	}
+	err := l.cmdWriteN(cmds)
+	if !commandSent(err) {
+		return err
+	}
+	for _, w := range writes {
+		w.s.setSent(w.val)
+	}
+	return err
+}
+
+// SetDeviceTargets sends a frame of targets to the devices on a serial bus with a
+// single write. Servo targets are sent with SetTarget commands and jrk targets with
+// SetTargetHighResolution commands. All entries are checked before any are sent.
+// Targets for this controller are checked against its e-stop state and servo limits.
+// Targets for other devices are only range checked (see Bus.SetDeviceTargets).
+// This requires the Pololu protocol.
+func (c *Controller) SetDeviceTargets(targets []DeviceTarget) error {
+	cmds, writes, err := c.deviceCmds(targets, func(device uint8) (*Controller, error) {
+		if device == c.device {
+			return c, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.writeDeviceTargets(cmds, writes)
+}
+
+// SetDeviceTargets sends a frame of targets to the devices on the bus with a single write.
+// Servo targets must be for controllers on the bus, and are checked against the e-stop
+// state and servo limits of their controller. Jrk targets are range checked.
+// All entries are checked before any are sent.
+func (b *Bus) SetDeviceTargets(targets []DeviceTarget) error {
+	if len(b.ctrl) == 0 {
+		return errors.New("no controllers on the bus")
+	}
+	l := &b.ctrl[0].link
+	cmds, writes, err := l.deviceCmds(targets, func(device uint8) (*Controller, error) {
+		for _, c := range b.ctrl {
+			if c.device == device {
+				return c, nil
+			}
+		}
+		return nil, fmt.Errorf("device %d is not on the bus", device)
+	})
+	if err != nil {
+		return err
+	}
+	return l.writeDeviceTargets(cmds, writes)
+}
+
+//-----------------------------------------------------------------------------
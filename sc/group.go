@@ -0,0 +1,99 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Groups
+
+A servo group is a set of servos (e.g. "the left leg") that can be
+commanded as a single object.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+
+// ServoGroup is a group of servos on a controller.
+type ServoGroup struct {
+	ctrl   *Controller // parent controller
+	servos []*Servo    // servos in the group
+}
+
+// NewServoGroup returns a new group of servos. The servos must belong to the controller.
+func (c *Controller) NewServoGroup(servos ...*Servo) (*ServoGroup, error) {
+	for _, s := range servos {
+		if s.ctrl != c {
+			return nil, fmt.Errorf("channel %d: servo is not on this controller", s.channel)
+		}
+	}
+	return &ServoGroup{
+		ctrl:   c,
+		servos: append([]*Servo(nil), servos...),
+	}, nil
+}
+
+// Servos returns the servos in the group.
+func (g *ServoGroup) Servos() []*Servo {
+	return append([]*Servo(nil), g.servos...)
+}
+
+// SetTargets sets the target values for the group. The targets are in group order
+// and are sent with a SetTargets command for each contiguous run of channels.
+func (g *ServoGroup) SetTargets(targets []uint16) error {
+	if len(targets) != len(g.servos) {
+		return fmt.Errorf("%d servos, %d targets", len(g.servos), len(targets))
+	}
+	f := make(Frame, len(targets))
+	for i, s := range g.servos {
+		if _, ok := f[s.channel]; ok {
+			return errors.New("duplicate servo channel in group")
+		}
+		f[s.channel] = targets[i]
+	}
+	return g.ctrl.writeFrame(f)
+}
+
+// SetSpeed sets the maximum speed for the servos in the group.
+func (g *ServoGroup) SetSpeed(speed Speed) error {
+	for _, s := range g.servos {
+		err := s.SetSpeed(speed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetAcceleration sets the maximum acceleration for the servos in the group.
+func (g *ServoGroup) SetAcceleration(acceleration Acceleration) error {
+	for _, s := range g.servos {
+		err := s.SetAcceleration(acceleration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disable stops the control pulses for the servos in the group.
+func (g *ServoGroup) Disable() error {
+	for _, s := range g.servos {
+		err := s.Disable()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GoHome sends the servos in the group to their host-side home positions.
+func (g *ServoGroup) GoHome() error {
+	return g.ctrl.GoHomeServos(g.servos...)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,70 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Target History
+
+An optional ring buffer records the most recent targets sent to a servo,
+for debugging the commanded trajectory of a misbehaving servo.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// TargetEvent is a target value sent to a servo.
+type TargetEvent struct {
+	Time   time.Time // time the target was sent
+	Target uint16    // target value
+}
+
+// history is a ring buffer of target events.
+type history struct {
+	events []TargetEvent // event buffer
+	next   int           // index of the next event
+	full   bool          // has the buffer wrapped?
+}
+
+// add adds an event to the history, overwriting the oldest event when full.
+func (h *history) add(target uint16) {
+	h.events[h.next] = TargetEvent{time.Now(), target}
+	h.next++
+	if h.next == len(h.events) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// EnableHistory records the last n targets sent to the servo (0 disables the history).
+// Any previous history is discarded.
+func (s *Servo) EnableHistory(n int) error {
+	if n < 0 {
+		return errors.New("negative history length")
+	}
+	if n == 0 {
+		s.history = nil
+		return nil
+	}
+	s.history = &history{events: make([]TargetEvent, n)}
+	return nil
+}
+
+// History returns the recorded targets sent to the servo (oldest first).
+func (s *Servo) History() []TargetEvent {
+	h := s.history
+	if h == nil {
+		return nil
+	}
+	if !h.full {
+		return append([]TargetEvent(nil), h.events[:h.next]...)
+	}
+	return append(append([]TargetEvent(nil), h.events[h.next:]...), h.events[:h.next]...)
+}
+
+//-----------------------------------------------------------------------------
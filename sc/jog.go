@@ -0,0 +1,113 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Jogging
+
+A jog goroutine moves the servo target at a constant rate (e.g. while a
+teach pendant button is held) until it is stopped or a servo limit is hit.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// jogger is the state of a jog goroutine.
+type jogger struct {
+	err  error         // write error
+	stop chan struct{} // closed to stop the jog
+	done chan struct{} // closed when the jog has stopped
+}
+
+// StartJog starts a goroutine that moves the servo target by rate ticks every
+// update interval (20ms), up (direction > 0) or down (direction < 0), starting from
+// the last target sent. The jog stops at the servo limits or when StopJog is called.
+// While jogging other target writes for this servo should not be made concurrently.
+// A jog that has stopped at a limit doesn't need to be stopped before starting another.
+func (s *Servo) StartJog(direction int, rate uint16) error {
+	if direction == 0 {
+		return errors.New("direction must be non-zero")
+	}
+	if rate == 0 {
+		return errors.New("rate must be > 0")
+	}
+	if s.Jogging() {
+		return errors.New("jog is already running")
+	}
+	if !s.sent || s.target == 0 {
+		return fmt.Errorf("channel %d: no target to jog from", s.channel)
+	}
+	step := int(rate)
+	if direction < 0 {
+		step = -step
+	}
+	j := &jogger{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.jog = j
+	go s.jogWriter(j, int(s.target), step)
+	return nil
+}
+
+// StopJog stops the jog goroutine (if it hasn't already stopped at a limit).
+// It returns the jog write error (if any).
+func (s *Servo) StopJog() error {
+	j := s.jog
+	if j == nil {
+		return nil
+	}
+	close(j.stop)
+	<-j.done
+	s.jog = nil
+	return j.err
+}
+
+// Jogging returns true if the jog goroutine is running.
+func (s *Servo) Jogging() bool {
+	j := s.jog
+	if j == nil {
+		return false
+	}
+	select {
+	case <-j.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// jogWriter is the jog goroutine.
+func (s *Servo) jogWriter(j *jogger, target, step int) {
+	defer close(j.done)
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+		}
+		target += step
+		limit := false
+		if target <= int(s.min) {
+			target, limit = int(s.min), true
+		}
+		if target >= int(s.max) {
+			target, limit = int(s.max), true
+		}
+		j.err = s.SetTarget(uint16(target))
+		if j.err != nil || limit {
+			return
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,234 @@
+//-----------------------------------------------------------------------------
+/*
+
+Jrk Motor Controller
+
+See: https://www.pololu.com/docs/pdf/0J38/jrk.pdf
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+
+// jrk commands
+const cmdSetTargetHighResolution = 0xc0
+const cmdSetTargetLowResolutionReverse = 0xe0
+const cmdSetTargetLowResolutionForward = 0xe1
+const cmdMotorOff = 0xff
+
+// jrk variable commands
+const cmdGetInput = 0xa1
+const cmdGetTarget = 0xa3
+const cmdGetFeedback = 0xa5
+const cmdGetScaledFeedback = 0xa7
+const cmdGetErrorSum = 0xa9
+const cmdGetDutyCycleTarget = 0xab
+const cmdGetDutyCycle = 0xad
+const cmdGetCurrent = 0x8f
+const cmdGetErrorFlagsHalting = 0xb3
+
+// 12 bits of jrk target
+const maxJrkTarget = 0xfff
+
+// 7 bits of low resolution target magnitude
+const maxJrkMagnitude = 0x7f
+
+//-----------------------------------------------------------------------------
+
+// JrkController is a jrk motor controller instance.
+type JrkController struct {
+	link // serial link to the controller
+}
+
+// NewJrkController returns a new jrk motor controller.
+// The jrk has its own error flags, so Config.AutoCheckErrors is not supported.
+func NewJrkController(cfg *Config) (*JrkController, error) {
+	if cfg.AutoCheckErrors {
+		return nil, errors.New("AutoCheckErrors is not supported for the jrk")
+	}
+	l, err := newLink(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	j := &JrkController{
+		link: l,
+	}
+	switch cfg.InitAction {
+	case InitAutoBaud:
+		err = j.autoBaud()
+	case InitClearErrors:
+		_, err = j.getVariable(cmdGetErrorFlagsHalting)
+	case InitNone:
+	default:
+		err = fmt.Errorf("bad init action %d", cfg.InitAction)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// jrkTarget returns the high resolution target command for a jrk device.
+func (l *link) jrkTarget(device uint8, target uint16) ([]byte, error) {
+	if target > maxJrkTarget {
+		return nil, fmt.Errorf("target > %d", maxJrkTarget)
+	}
+	cmd := l.devicePreamble(device, cmdSetTargetHighResolution|uint8(target&0x1f))
+	return append(cmd, byte((target>>5)&0x7f)), nil
+}
+
+// SetTargetHighResolution sets the jrk target value (0..4095).
+func (j *JrkController) SetTargetHighResolution(target uint16) error {
+	cmd, err := j.jrkTarget(j.device, target)
+	if err != nil {
+		return err
+	}
+	return j.cmdWrite(cmd)
+}
+
+// setJrkTargets sets the targets for jrks with consecutive device numbers.
+// No targets are sent while the emergency stop is triggered.
+func (c *Controller) setJrkTargets(device uint8, targets []uint16) error {
+	err := c.checkEStop()
+	if err != nil {
+		return err
+	}
+	if c.compact && len(targets) > 1 {
+		return errors.New("compact protocol can only set a single jrk target")
+	}
+	cmds := make([][]byte, len(targets))
+	for i, v := range targets {
+		dev := int(device) + i
+		if dev > maxDevice {
+			return fmt.Errorf("bad device number %d", dev)
+		}
+		cmd, err := c.jrkTarget(uint8(dev), v)
+		if err != nil {
+			return fmt.Errorf("%s for device %d", err.Error(), dev)
+		}
+		cmds[i] = cmd
+	}
+	return c.cmdWriteN(cmds)
+}
+
+// SetTargetForward sets a low resolution forward target (magnitude 0..127).
+func (j *JrkController) SetTargetForward(magnitude uint8) error {
+	if magnitude > maxJrkMagnitude {
+		return fmt.Errorf("magnitude > %d", maxJrkMagnitude)
+	}
+	cmd := j.cmdPreamble(cmdSetTargetLowResolutionForward)
+	cmd = append(cmd, magnitude)
+	return j.cmdWrite(cmd)
+}
+
+// SetTargetReverse sets a low resolution reverse target (magnitude 0..127).
+func (j *JrkController) SetTargetReverse(magnitude uint8) error {
+	if magnitude > maxJrkMagnitude {
+		return fmt.Errorf("magnitude > %d", maxJrkMagnitude)
+	}
+	cmd := j.cmdPreamble(cmdSetTargetLowResolutionReverse)
+	cmd = append(cmd, magnitude)
+	return j.cmdWrite(cmd)
+}
+
+// MotorOff turns the motor off. The jrk will restart the motor on the next target command.
+func (j *JrkController) MotorOff() error {
+	return j.cmdWrite(j.cmdPreamble(cmdMotorOff))
+}
+
+// getVariable reads a two byte jrk variable.
+func (j *JrkController) getVariable(command uint8) (uint16, error) {
+	return j.query16(j.cmdPreamble(command))
+}
+
+// GetTarget returns the jrk target value.
+func (j *JrkController) GetTarget() (uint16, error) {
+	return j.getVariable(cmdGetTarget)
+}
+
+// GetFeedback returns the raw jrk feedback value.
+func (j *JrkController) GetFeedback() (uint16, error) {
+	return j.getVariable(cmdGetFeedback)
+}
+
+// GetScaledFeedback returns the scaled jrk feedback value.
+func (j *JrkController) GetScaledFeedback() (uint16, error) {
+	return j.getVariable(cmdGetScaledFeedback)
+}
+
+// GetError returns the jrk PID error (scaled feedback - target).
+func (j *JrkController) GetError() (int, error) {
+	feedback, err := j.GetScaledFeedback()
+	if err != nil {
+		return 0, err
+	}
+	target, err := j.GetTarget()
+	if err != nil {
+		return 0, err
+	}
+	return int(feedback) - int(target), nil
+}
+
+// JrkVariables are the jrk controller variables.
+type JrkVariables struct {
+	Input           uint16 // analog/pulse input value
+	Target          uint16 // target value
+	Feedback        uint16 // raw feedback value
+	ScaledFeedback  uint16 // scaled feedback value
+	ErrorSum        int16  // integral of the error
+	DutyCycleTarget int16  // duty cycle the PID is requesting (-600..600)
+	DutyCycle       int16  // actual duty cycle (-600..600)
+	Current         uint8  // motor current (raw units)
+}
+
+// GetVariables returns the jrk controller variables.
+func (j *JrkController) GetVariables() (*JrkVariables, error) {
+	v := &JrkVariables{}
+	vars := []struct {
+		cmd uint8
+		val *uint16
+	}{
+		{cmdGetInput, &v.Input},
+		{cmdGetTarget, &v.Target},
+		{cmdGetFeedback, &v.Feedback},
+		{cmdGetScaledFeedback, &v.ScaledFeedback},
+	}
+	for _, x := range vars {
+		val, err := j.getVariable(x.cmd)
+		if err != nil {
+			return nil, err
+		}
+		*x.val = val
+	}
+	signed := []struct {
+		cmd uint8
+		val *int16
+	}{
+		{cmdGetErrorSum, &v.ErrorSum},
+		{cmdGetDutyCycleTarget, &v.DutyCycleTarget},
+		{cmdGetDutyCycle, &v.DutyCycle},
+	}
+	for _, x := range signed {
+		val, err := j.getVariable(x.cmd)
+		if err != nil {
+			return nil, err
+		}
+		*x.val = int16(val)
+	}
+	var buf [1]byte
+	err := j.query(j.cmdPreamble(cmdGetCurrent), buf[:])
+	if err != nil {
+		return nil, err
+	}
+	v.Current = buf[0]
+	return v, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,577 @@
+//-----------------------------------------------------------------------------
+/*
+
+Serial Link
+
+The serial link carries the command/response protocol common to the
+Maestro servo controllers and the jrk motor controllers.
+
+See: https://www.pololu.com/docs/pdf/0J40/maestro.pdf
+See: https://www.pololu.com/docs/pdf/0J38/jrk.pdf
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// read timeout when flushing pending input
+const flushTimeout = 10 * time.Millisecond
+
+// write backoff after the controller reports a full serial buffer
+const bufferFullDelay = 10 * time.Millisecond
+
+// maximum command frame length (SetMultipleTargets): preamble + count + channel + targets + crc
+const maxCmdFrame = 3 + 2 + 2*maxServos + 1
+
+// link is a serial link to a Pololu device.
+// Command writes and queries (write and response read) are serialized by the link
+// mutex. Devices on a shared serial bus share the same mutex.
+type link struct {
+	mu          *sync.Mutex       // serializes port access
+	tr          Transport         // serial transport
+	device      uint8             // device number
+	compact     bool              // use the compact protocol (single device on serial bus)
+	shared      bool              // is the serial bus shared with other devices?
+	crc         bool              // add a crc byte to outgoing commands
+	crcRead     bool              // check a crc byte after each response
+	readTimeout time.Duration     // response read timeout
+	flush       bool              // flush pending input before each query
+	delay       time.Duration     // delay after each command write
+	echo        bool              // read back and check the echo of each command write
+	bucket      *tokenBucket      // command rate limit (nil is no limit)
+	maxFrame    int               // maximum command frame length (0 is no limit)
+	deadline    time.Time         // response read deadline for the current query (zero for none)
+	autoCheck   bool              // read the controller errors after each command
+	hold        time.Time         // delay writes until this time (zero for none)
+	onWrite     func()            // called after each write (e.g. to kick the watchdog)
+	stats       Stats             // bus statistics
+	buf         [maxCmdFrame]byte // command buffer (guarded by the link mutex)
+}
+
+// Stats are serial bus statistics.
+type Stats struct {
+	Writes     uint64 // port writes
+	Reads      uint64 // successful response reads
+	ShortReads uint64 // short (or failed) response reads
+	CrcErrors  uint64 // serial crc errors reported by the controller
+	RspErrors  uint64 // responses with a bad crc byte
+	Errors     uint64 // non-zero error bitmaps reported by the controller
+}
+
+// Stats returns the serial bus statistics.
+func (l *link) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// ErrNoPort is returned by NewController (and ConfigBuilder.Build) if the configuration has no port or transport.
+var ErrNoPort = errors.New("no serial port in configuration")
+
+// errRspCrc is returned for a response with a bad crc byte.
+var errRspCrc = errors.New("response crc error")
+
+// TimeoutError is returned when a response isn't received before the read timeout.
+// The device may be slow or busy, so the query can be retried.
+// Other port read errors are returned as is.
+type TimeoutError struct {
+	Want int // response length
+	Got  int // bytes received
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("response timeout (%d of %d bytes)", e.Got, e.Want)
+}
+
+// Timeout returns true (see net.Error).
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// isTimeout returns true if an error is a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
+
+// isReadTimeout returns true if a transport read error is a timeout.
+// Ports without read deadlines (e.g. tarm/serial) return io.EOF on a read timeout.
+// On a port with read deadlines (e.g. net.Conn) io.EOF means the connection has
+// been closed, so it isn't a timeout.
+func (l *link) isReadTimeout(err error) bool {
+	if err == io.EOF {
+		_, deadlines := l.tr.(Deadliner)
+		return !deadlines
+	}
+	return isTimeout(err)
+}
+
+// newLink returns a serial link for the configuration.
+// The mutex serializes port access (nil allocates a new mutex, otherwise the serial bus is shared).
+func newLink(cfg *Config, mu *sync.Mutex) (link, error) {
+	tr := cfg.Transport
+	if tr == nil {
+		if cfg.Port == nil {
+			return link{}, ErrNoPort
+		}
+		tr = NewTransport(cfg.Port)
+	}
+	var bucket *tokenBucket
+	if cfg.MaxCommandsPerSecond < 0 {
+		return link{}, errors.New("negative command rate")
+	}
+	if cfg.MaxCommandsPerSecond != 0 {
+		bucket = newTokenBucket(cfg.MaxCommandsPerSecond)
+	}
+	shared := mu != nil
+	if !shared {
+		mu = &sync.Mutex{}
+	}
+	return link{
+		mu:          mu,
+		tr:          tr,
+		device:      cfg.DeviceNumber,
+		shared:      shared,
+		compact:     cfg.Compact,
+		crc:         cfg.Crc || cfg.CrcWrite,
+		crcRead:     cfg.CrcRead,
+		readTimeout: cfg.ReadTimeout,
+		flush:       cfg.FlushBeforeQuery,
+		delay:       cfg.InterCommandDelay,
+		echo:        cfg.EchoVerify,
+		bucket:      bucket,
+		maxFrame:    cfg.MaxFrameLength,
+		autoCheck:   cfg.AutoCheckErrors,
+	}, nil
+}
+
+// Close closes the transport (if it is an io.Closer).
+func (l *link) Close() error {
+	if c, ok := l.tr.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SetCompact selects the compact (single device on serial bus) or Pololu protocol for
+// subsequent commands. The compact protocol can't be used on a shared serial bus.
+// The device number is kept for the Pololu protocol. Don't call this while other
+// goroutines are sending commands.
+func (l *link) SetCompact(compact bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if compact && l.shared {
+		return errors.New("the compact protocol can't address multiple devices")
+	}
+	// The crc settings need no check. The controller crc setting applies to both
+	// protocols, and the crc byte is computed over the whole command frame (built
+	// for the current protocol when the command is sent).
+	l.compact = compact
+	return nil
+}
+
+// autoBaud sends a 0xaa for auto baud detection.
+func (l *link) autoBaud() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.write([]byte{0xaa})
+}
+
+func (l *link) cmdPreamble(command uint8) []byte {
+	return l.devicePreamble(l.device, command)
+}
+
+// devicePreamble returns the command preamble for a given device number.
+func (l *link) devicePreamble(device, command uint8) []byte {
+	if l.compact {
+		return []byte{command}
+	}
+	return []byte{0xaa, device, command & 0x7f}
+}
+
+// cmdFrame returns the command frame (with a crc byte if enabled).
+func (l *link) cmdFrame(cmd []byte) []byte {
+	if l.crc {
+		cmd = append(cmd, crc7(0, cmd)&0x7f)
+	}
+	return cmd
+}
+
+// checkFrame checks that the bytes following the command byte (or 0xaa) of a frame are
+// 7-bit data bytes. A data byte with the high bit set would be read as a new command,
+// so this is a final check for values that weren't range checked before packing.
+func checkFrame(frame []byte) error {
+	for i := 1; i < len(frame); i++ {
+		if frame[i] > 0x7f {
+			return fmt.Errorf("frame byte %d (0x%02x) is not a 7-bit data byte", i, frame[i])
+		}
+	}
+	return nil
+}
+
+// BuildFrame returns the command frame (preamble, payload and any crc byte) for a
+// command without sending it.
+func (l *link) BuildFrame(command uint8, payload []byte) []byte {
+	return l.cmdFrame(append(l.cmdPreamble(command), payload...))
+}
+
+// cmdWrite writes a command to the serial port.
+func (l *link) cmdWrite(cmd []byte) error {
+	err := checkFrame(cmd)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err = l.write(l.cmdFrame(cmd))
+	if err != nil {
+		return err
+	}
+	return l.autoCheckErrors()
+}
+
+// cmdWriteArgs writes a command with argument bytes to the serial port.
+// The command frame is built in the link command buffer, so it doesn't allocate.
+func (l *link) cmdWriteArgs(command uint8, args ...byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buf := l.buf[:0]
+	if l.compact {
+		buf = append(buf, command)
+	} else {
+		buf = append(buf, 0xaa, l.device, command&0x7f)
+	}
+	buf = l.cmdFrame(append(buf, args...))
+	err := checkFrame(buf)
+	if err != nil {
+		return err
+	}
+	if l.maxFrame != 0 && len(buf) > l.maxFrame {
+		return fmt.Errorf("%d byte command frame > %d byte limit (split the command)", len(buf), l.maxFrame)
+	}
+	err = l.write(buf)
+	if err != nil {
+		return err
+	}
+	return l.autoCheckErrors()
+}
+
+// cmdWriteN writes multiple commands to the serial port with a single write.
+// If an inter-command delay or a command rate limit is set the commands are written separately.
+func (l *link) cmdWriteN(cmds [][]byte) error {
+	for _, cmd := range cmds {
+		err := checkFrame(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.delay != 0 || l.bucket != nil {
+		for _, cmd := range cmds {
+			err := l.write(l.cmdFrame(cmd))
+			if err != nil {
+				return err
+			}
+		}
+		return l.autoCheckErrors()
+	}
+	buf := []byte{}
+	for _, cmd := range cmds {
+		buf = append(buf, l.cmdFrame(cmd)...)
+	}
+	err := l.write(buf)
+	if err != nil {
+		return err
+	}
+	return l.autoCheckErrors()
+}
+
+// sentError is an error from the error check after a command that has been written.
+// The command was sent, so the caller records its state before returning the error.
+type sentError struct {
+	err error
+}
+
+func (e *sentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sentError) Unwrap() error {
+	return e.err
+}
+
+// commandSent returns true if a command write error (or nil) means the command was sent.
+func commandSent(err error) bool {
+	if err == nil {
+		return true
+	}
+	_, ok := err.(*sentError)
+	return ok
+}
+
+// autoCheckErrors reads the controller errors after a command (if enabled).
+// A non-zero error bitmap is returned as a *ControllerError. Errors are returned
+// as a *sentError since the command has been written.
+func (l *link) autoCheckErrors() error {
+	if !l.autoCheck {
+		return nil
+	}
+	var buf [2]byte
+	err := l.write(l.cmdFrame(l.cmdPreamble(cmdGetErrors)))
+	if err != nil {
+		return &sentError{err}
+	}
+	err = l.rspRead(buf[:])
+	if err != nil {
+		return &sentError{err}
+	}
+	code := decode8(buf[0], buf[1])
+	l.noteErrors(code)
+	err = GetError(code)
+	if err != nil {
+		return &sentError{err}
+	}
+	return nil
+}
+
+// noteErrors updates the statistics for an error bitmap read from the controller.
+// If the controller serial buffer was full the next write is delayed to let it drain.
+func (l *link) noteErrors(code uint16) {
+	if code != 0 {
+		l.stats.Errors++
+	}
+	if code&uint16(ErrSerialCrc) != 0 {
+		l.stats.CrcErrors++
+	}
+	if code&uint16(ErrSerialBufferFull) != 0 {
+		l.hold = time.Now().Add(bufferFullDelay)
+	}
+}
+
+// write writes command frames to the serial port (with the link locked).
+func (l *link) write(buf []byte) error {
+	if !l.hold.IsZero() {
+		time.Sleep(time.Until(l.hold))
+		l.hold = time.Time{}
+	}
+	if l.bucket != nil {
+		l.bucket.wait()
+	}
+	l.stats.Writes++
+	err := l.tr.WriteFrame(buf)
+	if err != nil {
+		return err
+	}
+	if l.onWrite != nil {
+		l.onWrite()
+	}
+	if l.echo {
+		err := l.readEcho(buf)
+		if err != nil {
+			return err
+		}
+	}
+	if l.delay != 0 {
+		time.Sleep(l.delay)
+	}
+	return nil
+}
+
+// readEcho reads and checks the echo of a command write.
+func (l *link) readEcho(buf []byte) error {
+	err := l.setReadDeadline()
+	if err != nil {
+		return err
+	}
+	echo, err := l.tr.ReadN(len(buf))
+	if err != nil {
+		return fmt.Errorf("echo read: %s", err)
+	}
+	if len(echo) != len(buf) {
+		return errors.New("short echo read")
+	}
+	if !bytes.Equal(echo, buf) {
+		return errors.New("echo mismatch")
+	}
+	return nil
+}
+
+// rspRead reads a response from the serial port.
+func (l *link) rspRead(buf []byte) error {
+	if l.crcRead {
+		return l.rspReadCrc(buf)
+	}
+	return l.read(buf)
+}
+
+// rspReadCrc reads a response followed by a crc byte from the serial port.
+func (l *link) rspReadCrc(buf []byte) error {
+	rsp := make([]byte, len(buf)+1)
+	err := l.read(rsp)
+	if err != nil {
+		return err
+	}
+	n := len(buf)
+	if crc7(0, rsp[:n])&0x7f != rsp[n] {
+		l.stats.RspErrors++
+		return errRspCrc
+	}
+	copy(buf, rsp[:n])
+	return nil
+}
+
+// setReadDeadline sets the transport read deadline for the query deadline
+// or the read timeout (if supported).
+func (l *link) setReadDeadline() error {
+	d, ok := l.tr.(Deadliner)
+	if !ok {
+		return nil
+	}
+	if !l.deadline.IsZero() {
+		return d.SetDeadline(l.deadline)
+	}
+	if l.readTimeout != 0 {
+		return d.SetDeadline(time.Now().Add(l.readTimeout))
+	}
+	return nil
+}
+
+// read reads bytes from the serial port.
+func (l *link) read(buf []byte) error {
+	err := l.setReadDeadline()
+	if err != nil {
+		return err
+	}
+	rsp, err := l.tr.ReadN(len(buf))
+	if err != nil {
+		l.stats.ShortReads++
+		if l.isReadTimeout(err) {
+			return &TimeoutError{Want: len(buf), Got: len(rsp)}
+		}
+		return err
+	}
+	if len(rsp) != len(buf) {
+		l.stats.ShortReads++
+		return &TimeoutError{Want: len(buf), Got: len(rsp)}
+	}
+	copy(buf, rsp)
+	l.stats.Reads++
+	return nil
+}
+
+// FlushInput reads and discards any pending input on the serial port.
+// Use this after an error or an abandoned query to discard stale response
+// bytes that would otherwise be read as the response to the next query.
+// Ports with read deadlines wait for up to 10ms for input, other ports
+// wait for their read timeout.
+func (l *link) FlushInput() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushInput()
+}
+
+// flushInput discards any pending input on the transport (with the link locked).
+func (l *link) flushInput() error {
+	if f, ok := l.tr.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// query writes a command to the serial port and reads the response.
+// The link is locked across the write and the read.
+func (l *link) query(cmd, rsp []byte) error {
+	_, err := l.queryTimed(cmd, rsp)
+	return err
+}
+
+// queryTimed writes a command to the serial port and reads the response.
+// It returns the time at which the response was read.
+func (l *link) queryTimed(cmd, rsp []byte) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queryLocked(cmd, rsp)
+}
+
+// queryDeadline writes a command to the serial port and reads the response.
+// The response read deadline (if supported by the transport) overrides the read timeout.
+func (l *link) queryDeadline(cmd, rsp []byte, deadline time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deadline = deadline
+	_, err := l.queryLocked(cmd, rsp)
+	if !l.deadline.IsZero() {
+		// clear the deadline so it doesn't apply to later queries
+		l.deadline = time.Time{}
+		if d, ok := l.tr.(Deadliner); ok {
+			derr := d.SetDeadline(time.Time{})
+			if err == nil {
+				err = derr
+			}
+		}
+	}
+	return err
+}
+
+// queryLocked writes a command to the serial port and reads the response (with the link locked).
+// It returns the time at which the response was read.
+func (l *link) queryLocked(cmd, rsp []byte) (time.Time, error) {
+	if l.flush {
+		err := l.flushInput()
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	err := l.write(l.cmdFrame(cmd))
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = l.rspRead(rsp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now(), nil
+}
+
+// queryCrc writes a command to the serial port, with or without a crc byte, and reads the response.
+func (l *link) queryCrc(cmd, rsp []byte, crc bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	saved := l.crc
+	l.crc = crc
+	defer func() { l.crc = saved }()
+	err := l.write(l.cmdFrame(cmd))
+	if err != nil {
+		return err
+	}
+	return l.rspRead(rsp)
+}
+
+// query16 writes a command to the serial port and reads a two byte response.
+// The response bytes are raw 8-bit values (low byte first).
+func (l *link) query16(cmd []byte) (uint16, error) {
+	var buf [2]byte
+	err := l.query(cmd, buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return decode8(buf[0], buf[1]), nil
+}
+
+//-----------------------------------------------------------------------------
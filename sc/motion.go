@@ -0,0 +1,417 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Motion
+
+Higher level servo motion functions built on the basic commands.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// position polling interval
+const pollInterval = 20 * time.Millisecond
+
+// servo arrival tolerance and timeout for Traverse
+const arriveTolerance = 1 * uSec
+const arriveTimeout = 10 * time.Second
+
+// host-side interpolation update interval
+const updateInterval = 20 * time.Millisecond
+
+//-----------------------------------------------------------------------------
+
+// WaitForPosition polls the servo position until it is within tolerance of
+// the (logical) target with the servo trim applied. An error is returned on timeout.
+func (s *Servo) WaitForPosition(target, tolerance uint16, timeout time.Duration) error {
+	var pos uint16
+	want := s.trimmed(target)
+	err := waitFor(pollInterval, timeout, func() (bool, error) {
+		var err error
+		pos, err = s.GetPosition()
+		if err != nil {
+			return false, err
+		}
+		return absDiff(pos, want) <= tolerance, nil
+	})
+	if err != nil {
+		return fmt.Errorf("channel %d: %s (target %d, position %d)", s.channel, err, target, pos)
+	}
+	return nil
+}
+
+// SetTargetConfirm sets the servo target value and then polls the servo position until
+// it is within tolerance of the target. An error is returned on timeout.
+func (s *Servo) SetTargetConfirm(target, tolerance uint16, timeout time.Duration) error {
+	target, err := s.checkTarget(target)
+	if err != nil {
+		return err
+	}
+	err = s.SetTarget(target)
+	if err != nil {
+		return err
+	}
+	return s.WaitForPosition(target, tolerance, timeout)
+}
+
+// Traverse moves the servo to each waypoint in turn, waiting for it to arrive
+// (within arriveTolerance) and then holding for holdEach before the next waypoint.
+func (s *Servo) Traverse(waypoints []uint16, holdEach time.Duration) error {
+	for _, target := range waypoints {
+		err := s.SetTargetConfirm(target, arriveTolerance, arriveTimeout)
+		if err != nil {
+			return err
+		}
+		time.Sleep(holdEach)
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// SetAllTargets sets every configured servo to the same target, using a SetTargets
+// command for each contiguous run of channels. The target is checked against the
+// limits of each servo and the first error is returned.
+func (c *Controller) SetAllTargets(target uint16) error {
+	f := make(Frame)
+	for _, s := range c.servo {
+		if s != nil {
+			f[s.channel] = target
+		}
+	}
+	return c.writeFrame(f)
+}
+
+// interpolate returns the value at step i of n between from and to.
+func interpolate(from, to uint16, i, n int) uint16 {
+	return uint16(int(from) + (int(to)-int(from))*i/n)
+}
+
+// Sweep moves the servos together from one target value to another over a duration.
+// The intermediate targets are interpolated on the host and set with SetTargets.
+func (c *Controller) Sweep(servos []*Servo, from, to uint16, duration time.Duration) error {
+	for _, s := range servos {
+		if s.ctrl != c {
+			return fmt.Errorf("channel %d: servo is not on this controller", s.channel)
+		}
+	}
+	n := int(duration / updateInterval)
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i <= n; i++ {
+		if i != 0 {
+			time.Sleep(duration / time.Duration(n))
+		}
+		f := make(Frame, len(servos))
+		for _, s := range servos {
+			f[s.channel] = interpolate(from, to, i, n)
+		}
+		err := c.writeFrame(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InterpolatedPose moves the servos to their targets over a duration. The intermediate
+// targets are interpolated on the host hz times per second, using the easing function
+// (nil is Linear), and set with SetTargets. The servos arrive at the same time.
+func (c *Controller) InterpolatedPose(targets map[*Servo]uint16, duration time.Duration, hz int, fn Easing) error {
+	if hz <= 0 {
+		return errors.New("update rate must be > 0")
+	}
+	from := make(map[*Servo]uint16, len(targets))
+	to := make(map[*Servo]uint16, len(targets))
+	for s, target := range targets {
+		if s.ctrl != c {
+			return fmt.Errorf("channel %d: servo is not on this controller", s.channel)
+		}
+		var err error
+		to[s], err = s.checkTarget(target)
+		if err != nil {
+			return fmt.Errorf("channel %d: %s", s.channel, err)
+		}
+		from[s], err = s.start()
+		if err != nil {
+			return err
+		}
+	}
+	n := int(duration.Seconds() * float64(hz))
+	if n < 1 {
+		n = 1
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer ticker.Stop()
+	for i := 1; i <= n; i++ {
+		f := make(Frame, len(targets))
+		for s := range targets {
+			f[s.channel] = ease(from[s], to[s], float64(i)/float64(n), fn)
+		}
+		err := c.writeFrame(f)
+		if err != nil {
+			return err
+		}
+		if i != n {
+			<-ticker.C
+		}
+	}
+	return nil
+}
+
+// EstimateMoveTime returns the time the controller takes to move the servo target
+// between two values, using the last speed and acceleration limits set. The controller
+// motion profile is trapezoidal (accelerate, constant speed, decelerate). The time
+// taken by the servo to follow the target isn't included.
+func (s *Servo) EstimateMoveTime(from, to uint16) time.Duration {
+	d := float64(absDiff(from, to)) / uSec // us
+	v := s.speed.MicrosPerSec()            // us/s
+	a := s.accel.MicrosPerSecSq()          // us/s/s
+	var t float64
+	switch {
+	case d == 0 || (v == 0 && a == 0):
+		t = 0
+	case a == 0:
+		t = d / v
+	case v == 0 || d < v*v/a:
+		// accelerate for half the distance, decelerate for the other half
+		t = 2 * math.Sqrt(d/a)
+	default:
+		// accelerate to v, constant speed, decelerate
+		t = d/v + v/a
+	}
+	return time.Duration(t * float64(time.Second))
+}
+
+//-----------------------------------------------------------------------------
+
+// PowerUp sets the initial targets for the servos one at a time, spreading the
+// servo start ups evenly over the ramp duration. This avoids the power surge of
+// all servos moving to their initial targets at the same time.
+func (c *Controller) PowerUp(servos []*Servo, targets []uint16, ramp time.Duration) error {
+	if len(servos) != len(targets) {
+		return fmt.Errorf("%d servos, %d targets", len(servos), len(targets))
+	}
+	for i, s := range servos {
+		if i != 0 {
+			time.Sleep(ramp / time.Duration(len(servos)))
+		}
+		err := s.SetTarget(targets[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Step moves the servo target by a delta from the last target value sent (or the
+// current position if no target has been sent). The new target is clamped to the servo limits.
+func (s *Servo) Step(delta int16) error {
+	cur := s.target
+	if !s.sent {
+		var err error
+		cur, err = s.GetLogicalPosition()
+		if err != nil {
+			return err
+		}
+	}
+	target := int(cur) + int(delta)
+	if target < int(s.min) {
+		target = int(s.min)
+	}
+	if target > int(s.max) {
+		target = int(s.max)
+	}
+	return s.SetTarget(uint16(target))
+}
+
+//-----------------------------------------------------------------------------
+
+// WaitForStop polls the moving state until all servos have reached their targets.
+func (c *Controller) WaitForStop(poll, timeout time.Duration) error {
+	err := waitFor(poll, timeout, func() (bool, error) {
+		moving, err := c.GetMovingState()
+		return !moving, err
+	})
+	if err != nil {
+		return fmt.Errorf("wait for stop: %s", err)
+	}
+	return nil
+}
+
+// GoHomeAndWait sends all servos to their home position and waits until they have reached it.
+func (c *Controller) GoHomeAndWait(poll, timeout time.Duration) error {
+	err := c.GoHome()
+	if err != nil {
+		return err
+	}
+	return c.WaitForStop(poll, timeout)
+}
+
+//-----------------------------------------------------------------------------
+
+// center returns the midpoint of the servo target range.
+func (s *Servo) center() uint16 {
+	return s.min + (s.max-s.min)/2
+}
+
+// Center sets the servo target to the midpoint of its min..max range.
+func (s *Servo) Center() error {
+	return s.SetTarget(s.center())
+}
+
+// IsCentered returns true if the servo position is within tolerance of the midpoint of its min..max range
+// (with the servo trim applied).
+func (s *Servo) IsCentered(tolerance uint16) (bool, error) {
+	pos, err := s.GetPosition()
+	if err != nil {
+		return false, err
+	}
+	return absDiff(pos, s.trimmed(s.center())) <= tolerance, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// start returns the starting target for a host-side move.
+// This is the last target value sent, or the current position if no target has been sent.
+func (s *Servo) start() (uint16, error) {
+	if s.sent {
+		return s.target, nil
+	}
+	return s.GetLogicalPosition()
+}
+
+// MoveTo moves the servo to the target over a duration using a number of host-interpolated steps.
+func (s *Servo) MoveTo(target uint16, duration time.Duration, steps int) error {
+	return s.MoveToContext(context.Background(), target, duration, steps)
+}
+
+// MoveToContext moves the servo to the target over a duration using a number of host-interpolated steps.
+// If the context is canceled no further targets are sent (the servo is left where it
+// was at cancellation) and the context error is returned.
+func (s *Servo) MoveToContext(ctx context.Context, target uint16, duration time.Duration, steps int) error {
+	return s.MoveToEased(ctx, target, duration, steps, Linear)
+}
+
+// MoveToEased is MoveToContext with an easing function (nil is Linear) for the host-interpolated steps.
+func (s *Servo) MoveToEased(ctx context.Context, target uint16, duration time.Duration, steps int, fn Easing) error {
+	if steps < 1 {
+		steps = 1
+	}
+	from, err := s.start()
+	if err != nil {
+		return err
+	}
+	period := duration / time.Duration(steps)
+	if period <= 0 {
+		period = time.Nanosecond
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		err := s.SetTarget(ease(from, target, float64(i)/float64(steps), fn))
+		if err != nil {
+			return err
+		}
+		if i == steps {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Oscillate sweeps the servo back and forth between the low and high targets, with
+// a period for each low-high-low cycle, until the context is canceled. The first sweep
+// is from the last target sent (or the current position) to the high target.
+// It returns nil when the context is canceled, or the first target write error.
+func (s *Servo) Oscillate(ctx context.Context, low, high uint16, period time.Duration) error {
+	if period <= 0 {
+		return errors.New("period must be > 0")
+	}
+	for _, target := range []uint16{low, high} {
+		_, err := s.checkTarget(target)
+		if err != nil {
+			return err
+		}
+	}
+	half := period / 2
+	steps := int(half / updateInterval)
+	targets := [2]uint16{high, low}
+	for i := 0; ; i++ {
+		err := s.MoveToContext(ctx, targets[i%2], half, steps)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// SetHome sets the host-side home position for the servo (used by GoHomeServos).
+// This is independent of the home position stored in the controller settings.
+func (s *Servo) SetHome(target uint16) error {
+	target, err := s.checkTarget(target)
+	if err != nil {
+		return err
+	}
+	s.home = target
+	s.homeSet = true
+	return nil
+}
+
+// GoHomeServos sends the servos to their host-side home positions.
+// Unlike GoHome, servos that are not listed are not moved.
+func (c *Controller) GoHomeServos(servos ...*Servo) error {
+	f := make(Frame, len(servos))
+	for _, s := range servos {
+		if s.ctrl != c {
+			return fmt.Errorf("channel %d: servo is not on this controller", s.channel)
+		}
+		if !s.homeSet {
+			return fmt.Errorf("channel %d: no home position", s.channel)
+		}
+		f[s.channel] = s.home
+	}
+	return c.writeFrame(f)
+}
+
+// IsHome returns true if the servo position is within tolerance of its host-side home position.
+func (s *Servo) IsHome(tolerance uint16) (bool, error) {
+	if !s.homeSet {
+		return false, fmt.Errorf("channel %d: no home position", s.channel)
+	}
+	pos, err := s.GetPosition()
+	if err != nil {
+		return false, err
+	}
+	return absDiff(pos, s.trimmed(s.home)) <= tolerance, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,64 @@
+//-----------------------------------------------------------------------------
+/*
+
+Maestro Channel Probe
+
+The serial protocol has no command to report the controller model, so
+the channel count is inferred by querying servo positions until the
+controller stops responding.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"io"
+)
+
+//-----------------------------------------------------------------------------
+
+// Probe returns the number of servo channels on the controller attached to the port.
+// It issues GetPosition on increasing channel numbers (using the compact protocol)
+// and returns the highest channel that responded + 1. This is a best-effort heuristic:
+// the port should have a read timeout set so that a non-responding channel returns
+// a timeout rather than blocking forever, and any error state caused by probing a
+// non-existent channel is cleared before returning. Other port errors are returned.
+func Probe(port io.ReadWriter) (channels int, err error) {
+	c, err := NewController(&Config{
+		Port:    port,
+		Compact: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for ch := uint8(0); ch < maxServos; ch++ {
+		s, err := c.NewServo(ch)
+		if err != nil {
+			break
+		}
+		_, err = s.GetPosition()
+		if isTimeout(err) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		channels++
+	}
+	if channels == 0 {
+		return 0, errors.New("no response from controller")
+	}
+	// clear the (expected) serial protocol error caused by probing a non-existent channel
+	code, err := c.GetErrors()
+	if err != nil {
+		return 0, err
+	}
+	if code &^= uint16(ErrSerialProtocol); code != 0 {
+		return 0, GetError(code)
+	}
+	return channels, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,218 @@
+//-----------------------------------------------------------------------------
+/*
+
+Maestro Serial Protocol Tests
+
+These tests lock down the wire format of each command for the compact and
+Pololu protocols, with and without crc bytes.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/deadsy/maestro/sc/sctest"
+)
+
+//-----------------------------------------------------------------------------
+
+const testDevice = 12
+
+var protocolTests = []struct {
+	name    string
+	compact []byte // compact protocol command frame
+	rsp     []byte // response bytes
+	cmd     func(c *Controller) error
+}{
+	{"SetTarget", []byte{0x84, 0x01, 0x70, 0x2e}, nil, func(c *Controller) error {
+		return c.servo[1].SetTarget(6000)
+	}},
+	{"SetSpeed", []byte{0x87, 0x01, 0x0c, 0x01}, nil, func(c *Controller) error {
+		return c.servo[1].SetSpeed(140)
+	}},
+	{"SetAcceleration", []byte{0x89, 0x01, 0x04, 0x00}, nil, func(c *Controller) error {
+		return c.servo[1].SetAcceleration(4)
+	}},
+	{"SetPWM", []byte{0x8a, 0x60, 0x00, 0x40, 0x01}, nil, func(c *Controller) error {
+		return c.EnablePWM(0x60, 0xc0)
+	}},
+	{"GetPosition", []byte{0x90, 0x01}, []byte{0x70, 0x17}, func(c *Controller) error {
+		_, err := c.servo[1].GetPosition()
+		return err
+	}},
+	{"GetMovingState", []byte{0x93}, []byte{0x00}, func(c *Controller) error {
+		_, err := c.GetMovingState()
+		return err
+	}},
+	{"SetTargets", []byte{0x9f, 0x02, 0x02, 0x70, 0x2e, 0x58, 0x36}, nil, func(c *Controller) error {
+		return c.SetTargets(2, []uint16{6000, 7000})
+	}},
+	{"GetErrors", []byte{0xa1}, []byte{0x00, 0x00}, func(c *Controller) error {
+		_, err := c.GetErrors()
+		return err
+	}},
+	{"GoHome", []byte{0xa2}, nil, func(c *Controller) error {
+		return c.GoHome()
+	}},
+	{"StopScript", []byte{0xa4}, nil, func(c *Controller) error {
+		return c.StopScript()
+	}},
+	{"RestartScript", []byte{0xa7, 0x02}, nil, func(c *Controller) error {
+		return c.RestartScript(2)
+	}},
+	{"RestartScriptParms", []byte{0xa8, 0x02, 0x68, 0x07}, nil, func(c *Controller) error {
+		return c.RestartScriptParms(2, 1000)
+	}},
+	{"GetScriptStatus", []byte{0xae}, []byte{0x01}, func(c *Controller) error {
+		_, err := c.GetScriptStatus()
+		return err
+	}},
+}
+
+// pololuFrame converts a compact protocol frame to a Pololu protocol frame.
+func pololuFrame(device uint8, compact []byte) []byte {
+	frame := []byte{0xaa, device, compact[0] & 0x7f}
+	return append(frame, compact[1:]...)
+}
+
+// crcFrame appends a crc byte to a frame.
+func crcFrame(frame []byte) []byte {
+	return append(append([]byte(nil), frame...), crc7Ref(frame))
+}
+
+func TestProtocol(t *testing.T) {
+	for _, compact := range []bool{true, false} {
+		for _, crc := range []bool{false, true} {
+			port := sctest.NewPort()
+			c, err := NewController(&Config{
+				Port:         port,
+				DeviceNumber: testDevice,
+				Compact:      compact,
+				Crc:          crc,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// auto baud detection byte
+			port.AssertFrame(t, []byte{0xaa})
+			for ch := uint8(0); ch < 4; ch++ {
+				c.NewServo(ch)
+			}
+			for _, v := range protocolTests {
+				name := fmt.Sprintf("%s/compact=%v/crc=%v", v.name, compact, crc)
+				t.Run(name, func(t *testing.T) {
+					port.Reset()
+					port.QueueResponse(v.rsp...)
+					err := v.cmd(c)
+					if err != nil {
+						t.Fatal(err)
+					}
+					expect := v.compact
+					if !compact {
+						expect = pololuFrame(testDevice, expect)
+					}
+					if crc {
+						expect = crcFrame(expect)
+					}
+					port.AssertFrame(t, expect)
+					port.AssertNoFrames(t)
+					if port.Pending() != 0 {
+						t.Error("response not read")
+					}
+				})
+			}
+		}
+	}
+}
+
+// compact frames never include the device number
+func TestCompactDevice(t *testing.T) {
+	for _, device := range []uint8{0, 1, testDevice, maxDevice} {
+		port := sctest.NewPort()
+		c, err := NewController(&Config{Port: port, DeviceNumber: device, Compact: true, InitAction: InitNone})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.State().Device != 0 {
+			t.Errorf("device %d: state device %d", device, c.State().Device)
+		}
+		for ch := uint8(0); ch < 4; ch++ {
+			c.NewServo(ch)
+		}
+		for _, v := range protocolTests {
+			port.Reset()
+			port.QueueResponse(v.rsp...)
+			err := v.cmd(c)
+			if err != nil {
+				t.Fatalf("%s/device=%d: %s", v.name, device, err)
+			}
+			port.AssertFrame(t, v.compact)
+		}
+	}
+	_, err := NewConfig(sctest.NewPort()).Compact().WithDevice(testDevice).Build()
+	if err == nil {
+		t.Error("expected an error for a compact device number")
+	}
+}
+
+func TestBuildFrame(t *testing.T) {
+	for _, compact := range []bool{true, false} {
+		for _, crc := range []bool{false, true} {
+			port := sctest.NewPort()
+			c, err := NewController(&Config{
+				Port:         port,
+				DeviceNumber: testDevice,
+				Compact:      compact,
+				Crc:          crc,
+				InitAction:   InitNone,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := []byte{cmdSetTarget, 0x01, 0x70, 0x2e}
+			if !compact {
+				expect = pololuFrame(testDevice, expect)
+			}
+			if crc {
+				expect = crcFrame(expect)
+			}
+			frame := c.BuildFrame(cmdSetTarget, []byte{0x01, 0x70, 0x2e})
+			if !bytes.Equal(frame, expect) {
+				t.Errorf("compact=%v/crc=%v: frame %v, expected %v", compact, crc, frame, expect)
+			}
+			port.AssertNoFrames(t)
+		}
+	}
+}
+
+func TestCheckFrame(t *testing.T) {
+	for _, compact := range []bool{true, false} {
+		port := sctest.NewPort()
+		c, err := NewController(&Config{
+			Port:         port,
+			DeviceNumber: testDevice,
+			Compact:      compact,
+			InitAction:   InitNone,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.cmdWriteArgs(cmdSetTarget, 0x01, 0x80, 0x2e); err == nil {
+			t.Errorf("compact=%v: expected a 7-bit data error", compact)
+		}
+		if err := c.cmdWrite(append(c.cmdPreamble(cmdSetTarget), 0x01, 0xff, 0x2e)); err == nil {
+			t.Errorf("compact=%v: expected a 7-bit data error", compact)
+		}
+		port.AssertNoFrames(t)
+		if err := c.cmdWriteArgs(cmdSetTarget, 0x01, 0x70, 0x2e); err != nil {
+			t.Errorf("compact=%v: %v", compact, err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,92 @@
+//-----------------------------------------------------------------------------
+/*
+
+Mini Maestro PWM Output
+
+The Mini Maestro 12, 18 and 24 have a hardware PWM output on a fixed channel
+(channel 8 on the Mini Maestro 12, channel 12 on the Mini Maestro 18 and 24).
+While the PWM output is enabled that channel can't be used as a servo, so
+target commands for it are rejected.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+
+// pwmChannel returns the channel used for the PWM output.
+func (c *Controller) pwmChannel() (uint8, error) {
+	switch c.channels {
+	case 12:
+		return 8, nil
+	case 18, 24:
+		return 12, nil
+	}
+	return 0, errors.New("no PWM output on the Micro Maestro")
+}
+
+// EnablePWM enables the PWM output with an on time and period in units of 1/48us.
+// Servo targets for the PWM channel are rejected until the PWM output is disabled.
+func (c *Controller) EnablePWM(ontime, period uint16) error {
+	_, err := c.pwmChannel()
+	if err != nil {
+		return err
+	}
+	err = c.setPWM(ontime, period)
+	if !commandSent(err) {
+		return err
+	}
+	c.pwm = ontime != 0 || period != 0
+	return err
+}
+
+// DisablePWM disables the PWM output.
+func (c *Controller) DisablePWM() error {
+	err := c.setPWM(0, 0)
+	if !commandSent(err) {
+		return err
+	}
+	c.pwm = false
+	return err
+}
+
+// PWMEnabled returns true if the PWM output is enabled.
+func (c *Controller) PWMEnabled() bool {
+	return c.pwm
+}
+
+// setPWM sends a SetPWM command.
+func (c *Controller) setPWM(ontime, period uint16) error {
+	x0, err := pack14(ontime)
+	if err != nil {
+		return err
+	}
+	x1, err := pack14(period)
+	if err != nil {
+		return err
+	}
+	cmd := c.cmdPreamble(cmdSetPWM)
+	cmd = append(cmd, x0[:]...)
+	cmd = append(cmd, x1[:]...)
+	return c.cmdWrite(cmd)
+}
+
+// checkPWM returns an error if the channel is in use as the PWM output.
+func (c *Controller) checkPWM(channel uint8) error {
+	if !c.pwm {
+		return nil
+	}
+	ch, _ := c.pwmChannel()
+	if channel == ch {
+		return fmt.Errorf("channel %d is in use as the PWM output", channel)
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
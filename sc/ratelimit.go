@@ -0,0 +1,119 @@
+//-----------------------------------------------------------------------------
+/*
+
+Rate Limited Servo Targets
+
+When target updates arrive faster than they should be sent (e.g. from a GUI
+slider) a writer goroutine coalesces them and sends the latest target at
+a limited rate.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// rateLimiter is the state of a rate limited target writer.
+type rateLimiter struct {
+	mu      sync.Mutex
+	pending bool          // is there a target to send?
+	target  uint16        // latest target
+	err     error         // last write error
+	stop    chan struct{} // closed to stop the writer
+	done    chan struct{} // closed when the writer has stopped
+}
+
+// StartRateLimiter starts a writer goroutine that sends the latest target given to
+// SetTargetRateLimited at most hz times per second. While the writer is running
+// other target writes for this servo should not be made concurrently.
+func (s *Servo) StartRateLimiter(hz float64) error {
+	if hz <= 0 {
+		return errors.New("rate must be > 0")
+	}
+	if s.limiter != nil {
+		return errors.New("rate limiter is already running")
+	}
+	rl := &rateLimiter{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	s.limiter = rl
+	go s.rateWriter(rl, time.Duration(float64(time.Second)/hz))
+	return nil
+}
+
+// StopRateLimiter sends any pending target and stops the writer goroutine.
+// It returns the last write error.
+func (s *Servo) StopRateLimiter() error {
+	rl := s.limiter
+	if rl == nil {
+		return nil
+	}
+	close(rl.stop)
+	<-rl.done
+	s.limiter = nil
+	return rl.err
+}
+
+// rateWriter is the writer goroutine.
+func (s *Servo) rateWriter(rl *rateLimiter, period time.Duration) {
+	defer close(rl.done)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rl.stop:
+			s.ratePending(rl)
+			return
+		case <-ticker.C:
+			s.ratePending(rl)
+		}
+	}
+}
+
+// ratePending sends the pending target (if any).
+func (s *Servo) ratePending(rl *rateLimiter) {
+	rl.mu.Lock()
+	target, pending := rl.target, rl.pending
+	rl.pending = false
+	rl.mu.Unlock()
+	if !pending {
+		return
+	}
+	err := s.SetTarget(target)
+	if err != nil {
+		rl.mu.Lock()
+		rl.err = err
+		rl.mu.Unlock()
+	}
+}
+
+// SetTargetRateLimited sets the latest target for the rate limited writer.
+// The target is checked immediately, and the last write error from the writer
+// goroutine (if any) is returned.
+func (s *Servo) SetTargetRateLimited(target uint16) error {
+	rl := s.limiter
+	if rl == nil {
+		return errors.New("rate limiter is not running")
+	}
+	target, err := s.checkTarget(target)
+	if err != nil {
+		return err
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.target = target
+	rl.pending = true
+	err = rl.err
+	rl.err = nil
+	return err
+}
+
+//-----------------------------------------------------------------------------
@@ -11,10 +11,14 @@ See: https://www.pololu.com/docs/pdf/0J40/maestro.pdf
 package sc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //-----------------------------------------------------------------------------
@@ -33,10 +37,6 @@ const cmdStopScript = 0xa4
 const cmdRestartScript = 0xa7
 const cmdRestartScriptParms = 0xa8
 const cmdGetScriptStatus = 0xae
-const cmdSetTargetHighResolution = 0xc0       // jrk motor controller
-const cmdSetTargetLowResolutionReverse = 0xe0 // jrk motor controller
-const cmdSetTargetLowResolutionForward = 0xe1 // jrk motor controller
-const cmdMotorOff = 0xff                      // jrk motor controller
 
 // position ticks per uSec of servo control pulse
 const uSec = 4
@@ -44,137 +44,409 @@ const uSec = 4
 // 14 bits of target position
 const maxTarget = 0x3fff
 
+// maximum script subroutine number (7 bits)
+const maxSubroutine = 0x7f
+
 // maximum number of servos per controller
 const maxServos = 24
 
 //-----------------------------------------------------------------------------
 
-// GetError converts an error bitmap into a go error object.
-func GetError(val uint16) error {
-	errorStrings := []string{
-		"serial signal error",          // bit 0
-		"serial overrun error",         // bit 1
-		"serial buffer full",           // bit 2
-		"serial crc error",             // bit 3
-		"serial protocol error",        // bit 4
-		"serial timeout",               // bit 5
-		"script stack error",           // bit 6
-		"script call stack error",      // bit 7
-		"script program counter error", // bit 8
+// ErrorCode is a bit in the controller error bitmap.
+type ErrorCode uint16
+
+// Controller error bits.
+const (
+	ErrSerialSignal     ErrorCode = 1 << iota // bit 0
+	ErrSerialOverrun                          // bit 1
+	ErrSerialBufferFull                       // bit 2
+	ErrSerialCrc                              // bit 3
+	ErrSerialProtocol                         // bit 4
+	ErrSerialTimeout                          // bit 5
+	ErrScriptStack                            // bit 6
+	ErrScriptCallStack                        // bit 7
+	ErrScriptPC                               // bit 8
+)
+
+var errorStrings = []string{
+	"serial signal error",          // bit 0
+	"serial overrun error",         // bit 1
+	"serial buffer full",           // bit 2
+	"serial crc error",             // bit 3
+	"serial protocol error",        // bit 4
+	"serial timeout",               // bit 5
+	"script stack error",           // bit 6
+	"script call stack error",      // bit 7
+	"script program counter error", // bit 8
+}
+
+func (e ErrorCode) String() string {
+	for i, s := range errorStrings {
+		if e == 1<<i {
+			return s
+		}
 	}
-	s := []string{}
-	for i, err := range errorStrings {
+	return fmt.Sprintf("error code 0x%04x", uint16(e))
+}
+
+// DecodeErrors converts an error bitmap into a list of error codes.
+func DecodeErrors(val uint16) []ErrorCode {
+	codes := []ErrorCode{}
+	for i := range errorStrings {
 		if val&(1<<i) != 0 {
-			s = append(s, err)
+			codes = append(codes, ErrorCode(1<<i))
 		}
 	}
-	if len(s) == 0 {
+	return codes
+}
+
+// ControllerError is a non-zero controller error bitmap.
+type ControllerError struct {
+	Bits uint16 // error bitmap
+}
+
+func (e *ControllerError) Error() string {
+	s := []string{}
+	for _, code := range DecodeErrors(e.Bits) {
+		s = append(s, code.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// ErrBufferFull matches (with errors.Is) a *ControllerError with the serial buffer full bit set.
+// Commands have been sent faster than the controller can process them, and some have been lost.
+var ErrBufferFull = errors.New("serial buffer full")
+
+// Is returns true for ErrBufferFull if the serial buffer full bit is set.
+func (e *ControllerError) Is(target error) bool {
+	return target == ErrBufferFull && e.Has(ErrSerialBufferFull)
+}
+
+// Has returns true if the error code bit is set.
+func (e *ControllerError) Has(code ErrorCode) bool {
+	return e.Bits&uint16(code) != 0
+}
+
+// GetError converts an error bitmap into a go error object (a *ControllerError, or nil for no errors).
+func GetError(val uint16) error {
+	if len(DecodeErrors(val)) == 0 {
 		return nil
 	}
-	return errors.New(strings.Join(s, ","))
+	return &ControllerError{Bits: val}
+}
+
+// waitFor polls a condition function until it returns true or the timeout expires.
+func waitFor(poll, timeout time.Duration, cond func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := cond()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timeout")
+		}
+		time.Sleep(poll)
+	}
 }
 
 //-----------------------------------------------------------------------------
 // Controller
 
 // Config is the servo controller configuration.
+//
+// In its default "USB Dual Port" or "UART, detect baud rate" serial modes the
+// Maestro detects the baud rate (300 to 200000 baud) from the 0xaa byte sent
+// by NewController, so any standard rate (e.g. 115200) may be used on the port.
+//
+// Query responses are read with the port's own timeout. If ReadTimeout is non-zero
+// and the port has a SetReadDeadline method (e.g. net.Conn) a deadline is set for
+// each response. Ports without deadlines (e.g. tarm/serial) should be opened with a
+// read timeout so a missing response doesn't block forever.
+//
+// If the port is an io.ReadWriteCloser it will be closed by Controller.Close.
+// The port is adapted to a Transport with NewTransport.
 type Config struct {
 	Port         io.ReadWriter // serial port
-	DeviceNumber uint8         // device number
+	Transport    Transport     // serial transport (used instead of Port if set)
+	DeviceNumber uint8         // device number (ignored for the compact protocol)
 	Compact      bool          // use the compact protocol (single device on serial bus)
-	Crc          bool          // add a crc byte to outgoing commands
+	Crc          bool          // add a crc byte to outgoing commands (same as CrcWrite)
+	CrcWrite     bool          // add a crc byte to outgoing commands
+	CrcRead      bool          // check a crc byte after each response (not sent by the Maestro)
+	ReadTimeout  time.Duration // response read timeout (0 uses the port timeout)
+	// Discard any pending input (see FlushInput) before each query. Use this if a
+	// previous command may have been aborted or errored, leaving stale response bytes
+	// on the port. For ports without read deadlines each flush waits for the port read timeout.
+	FlushBeforeQuery bool
+	// Delay after each command write. Some serial links (e.g. slow USB bridges)
+	// drop commands that are sent back-to-back too quickly. Zero is no delay.
+	InterCommandDelay time.Duration
+	// Read back and check the echo of each command write. Use this on a half-duplex
+	// (e.g. RS-485) bus where the transmitter receives its own bytes, so the echo
+	// isn't read as the response to a query.
+	EchoVerify bool
+	// Maximum number of commands written per second by the controller. Up to 8
+	// commands may be written back-to-back, after which writes wait to keep to the
+	// rate. Commands batched by ConfigureServos are written separately. Zero is no limit.
+	MaxCommandsPerSecond float64
+	// Maximum command frame length in bytes. A SetTargets command that would exceed
+	// this returns an error rather than risk overrunning the controller receive
+	// buffer, and should be split into smaller runs of channels. Zero is no limit.
+	MaxFrameLength int
+	// Read the controller errors after each command (not after queries). Errors are
+	// returned by the command as a *ControllerError (use errors.As). The command has
+	// still been sent, so its state (e.g. the servo target) is recorded. If the
+	// controller serial buffer was full (see ErrBufferFull) the next write is delayed
+	// to let the buffer drain. This doubles the serial traffic for commands.
+	AutoCheckErrors bool
+	// Minimum interval between GetMovingState queries. Calls within the interval
+	// return the cached moving state rather than querying the controller. This
+	// avoids flooding the bus from tight polling loops at the cost of reporting
+	// a moving state up to the interval old. Zero always queries the controller.
+	MovingStateInterval time.Duration
+	InitAction          InitAction // action taken by the constructor
+	TargetMode          TargetMode // command used by SetTargets
+	Channels            int        // number of servo channels (6, 12, 18 or 24; 0 is 24)
 }
 
+// TargetMode selects the command used by SetTargets.
+type TargetMode int
+
+// SetTargets modes.
+const (
+	// Set multiple servo targets with one SetMultipleTargets command (default).
+	TargetModeServo TargetMode = iota
+	// Set jrk motor controller targets with SetTargetHighResolution commands.
+	// The channel number is the device number of the first jrk, and each target
+	// (0..4095) is sent to the jrk with the next device number. The compact
+	// protocol has no device addressing, so it can only set a single target.
+	// Only SetTargets is changed, servo frame functions (e.g. SetAllTargets) still
+	// set servo targets.
+	TargetModeJrk
+)
+
+// ErrAutoBaud is returned (wrapped) by NewController if the auto baud byte can't be written.
+// This usually means the serial device is missing or misconfigured.
+var ErrAutoBaud = errors.New("auto baud write failed")
+
+// InitAction is the action taken when a controller is created.
+type InitAction int
+
+// Controller initialisation actions.
+const (
+	InitAutoBaud    InitAction = iota // send 0xaa for auto baud detection (default)
+	InitClearErrors                   // read (and clear) the controller errors
+	InitNone                          // send nothing
+)
+
 // Controller is a servo controller instance.
+// Command writes and queries are serialized, and a query holds the serial port
+// across its command write and response read, so commands from other goroutines
+// can't be interleaved with a response. Targets for a servo should only be set
+// from one goroutine at a time.
 type Controller struct {
-	port    io.ReadWriter     // serial port
-	device  uint8             // device number
-	compact bool              // use the compact protocol (single device on serial bus)
-	crc     bool              // add a crc byte to outgoing commands
-	servo   [maxServos]*Servo // child servos
+	link                                        // serial link to the controller
+	servo    [maxServos]*Servo                  // child servos
+	onTarget func(channel uint8, target uint16) // target set callback
+	mode     TargetMode                         // command used by SetTargets
+	channels int                                // number of servo channels
+	pwm      bool                               // is the PWM output enabled?
+	subs     map[string]uint8                   // named script subroutines
+	estop    int32                              // is the emergency stop triggered? (atomic)
+	estopFn  func() error                       // emergency stop function
+	wd       watchdog                           // command watchdog
+	// moving state caching (guarded by the link mutex)
+	movingInterval time.Duration // minimum interval between moving state queries
+	movingTime     time.Time     // time of the last moving state query
+	moving         bool          // cached moving state
 }
 
 // NewController returns a new servo motor controller.
 func NewController(cfg *Config) (*Controller, error) {
+	return newController(cfg, nil)
+}
+
+// newController returns a new servo motor controller using a (possibly shared) link mutex.
+func newController(cfg *Config, mu *sync.Mutex) (*Controller, error) {
+	l, err := newLink(cfg, mu)
+	if err != nil {
+		return nil, err
+	}
 	c := &Controller{
-		port:    cfg.Port,
-		device:  cfg.DeviceNumber,
-		compact: cfg.Compact,
-		crc:     cfg.Crc,
+		link:           l,
+		movingInterval: cfg.MovingStateInterval,
+		mode:           cfg.TargetMode,
+		channels:       cfg.Channels,
+	}
+	c.onWrite = c.kickWatchdog
+	if c.channels == 0 {
+		c.channels = maxServos
+	}
+	switch c.channels {
+	case 6, 12, 18, 24:
+	default:
+		return nil, fmt.Errorf("bad number of channels %d", cfg.Channels)
+	}
+	switch cfg.InitAction {
+	case InitAutoBaud:
+		err = c.autoBaud()
+		if err != nil {
+			err = fmt.Errorf("%w: %s", ErrAutoBaud, err)
+		}
+	case InitClearErrors:
+		_, err = c.GetErrors()
+	case InitNone:
+	default:
+		err = fmt.Errorf("bad init action %d", cfg.InitAction)
 	}
-	// send a 0xaa for auto baud detection
-	_, err := c.port.Write([]byte{0xaa})
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-func (c *Controller) cmdPreamble(command uint8) []byte {
-	if c.compact {
-		return []byte{command}
-	}
-	return []byte{0xaa, c.device, command & 0x7f}
-}
-
-// cmdWrite writes a command to the serial port.
-func (c *Controller) cmdWrite(cmd []byte) error {
-	if c.crc {
-		cmd = append(cmd, crc7(0, cmd)&0x7f)
-	}
-	_, err := c.port.Write(cmd)
-	if err != nil {
-		return err
-	}
-	return nil
+// Close stops the watchdog and closes the serial port (if it is an io.Closer).
+func (c *Controller) Close() error {
+	c.StopWatchdog()
+	return c.link.Close()
 }
 
-// rspRead reads a response from the serial port.
-func (c *Controller) rspRead(buf []byte) error {
-	n, err := c.port.Read(buf)
-	if err != nil {
-		return err
-	}
-	if n != len(buf) {
-		return errors.New("short read")
-	}
-	return nil
+// OnTargetSet sets a callback function that is called after each successful
+// target write (SetTarget or SetTargets) with the channel and target value sent.
+func (c *Controller) OnTargetSet(fn func(channel uint8, target uint16)) {
+	c.onTarget = fn
 }
 
 // GetMovingState returns true if the controller has not reached the target value for all servos.
 // True implies the servos are moving. False does not imply the servos have stopped moving.
+// If Config.MovingStateInterval is set a cached value may be returned.
 func (c *Controller) GetMovingState() (bool, error) {
-	err := c.cmdWrite(c.cmdPreamble(cmdGetMovingState))
-	if err != nil {
-		return false, err
+	// the link mutex also guards the cached moving state
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.movingInterval != 0 && time.Since(c.movingTime) < c.movingInterval {
+		return c.moving, nil
 	}
 	var buf [1]byte
-	err = c.rspRead(buf[:])
+	_, err := c.queryLocked(c.cmdPreamble(cmdGetMovingState), buf[:])
 	if err != nil {
 		return false, err
 	}
-	return buf[0] != 0, nil
+	c.moving = decodeBool(buf[0])
+	c.movingTime = time.Now()
+	return c.moving, nil
 }
 
 // GetErrors returns the controller error code.
+//
+// Note: reading the errors clears the controller error register, so each error is
+// only reported once. If the response has a bad crc byte (see Config.CrcRead) the
+// read is retried once, but the errors in the corrupted response have been cleared.
 func (c *Controller) GetErrors() (uint16, error) {
-	err := c.cmdWrite(c.cmdPreamble(cmdGetErrors))
+	code, err := c.query16(c.cmdPreamble(cmdGetErrors))
+	if errors.Is(err, errRspCrc) {
+		code, err = c.query16(c.cmdPreamble(cmdGetErrors))
+	}
 	if err != nil {
 		return 0, err
 	}
+	c.mu.Lock()
+	c.noteErrors(code)
+	c.mu.Unlock()
+	return code, nil
+}
+
+// ClearErrors clears the controller error register (by reading it).
+func (c *Controller) ClearErrors() error {
+	_, err := c.GetErrors()
+	return err
+}
+
+// CheckErrors reads (and clears) the controller error bitmap. The bitmap is returned
+// with its decoded *ControllerError (nil for no errors). Serial port errors are
+// returned with a zero bitmap.
+func (c *Controller) CheckErrors() (uint16, error) {
+	code, err := c.GetErrors()
+	if err != nil {
+		return 0, err
+	}
+	return code, GetError(code)
+}
+
+// getErrorsCrc reads the error bitmap with or without a crc byte on the command.
+func (c *Controller) getErrorsCrc(crc bool) (uint16, error) {
 	var buf [2]byte
-	err = c.rspRead(buf[:])
+	err := c.queryCrc(c.cmdPreamble(cmdGetErrors), buf[:], crc)
 	if err != nil {
 		return 0, err
 	}
-	return (uint16(buf[0]) & 0x7f) + (uint16(buf[1])&0x7f)<<8, nil
+	return decode8(buf[0], buf[1]), nil
+}
+
+// VerifyCRCMode checks that the crc setting for outgoing commands matches the controller.
+// A controller expecting a crc byte ignores commands without one, and a controller not
+// expecting one reads the crc byte as a protocol error. It reads (and clears) the controller errors.
+func (c *Controller) VerifyCRCMode() error {
+	// the first read clears any stale errors
+	_, err := c.getErrorsCrc(c.crc)
+	if err == nil {
+		var code uint16
+		code, err = c.getErrorsCrc(c.crc)
+		if err == nil {
+			if c.crc && code&uint16(ErrSerialProtocol) != 0 {
+				return errors.New("crc mode mismatch: the controller is not configured for crc (clear Config.Crc)")
+			}
+			if code&uint16(ErrSerialCrc) != 0 {
+				return errors.New("serial crc errors: check the serial link")
+			}
+			return nil
+		}
+	}
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		return err
+	}
+	// no response, try the other crc mode
+	_, err2 := c.getErrorsCrc(!c.crc)
+	if err2 != nil {
+		return fmt.Errorf("no response from the controller: %s", err)
+	}
+	if c.crc {
+		return errors.New("crc mode mismatch: the controller is not configured for crc (clear Config.Crc)")
+	}
+	return errors.New("crc mode mismatch: the controller expects a crc byte (set Config.Crc)")
 }
 
 // GoHome sends all servos to their home position.
 func (c *Controller) GoHome() error {
+	err := c.checkEStop()
+	if err != nil {
+		return err
+	}
 	return c.cmdWrite(c.cmdPreamble(cmdGoHome))
 }
 
+// SafeHome reads and clears the controller errors, re-sends the auto baud
+// byte if there were errors, and then sends all servos to their home position.
+// After a fault GoHome may not execute reliably until the error state is cleared.
+func (c *Controller) SafeHome() error {
+	code, err := c.GetErrors()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		err = c.autoBaud()
+		if err != nil {
+			return err
+		}
+	}
+	return c.GoHome()
+}
+
 // StopScript stops the execution of a servo user script.
 func (c *Controller) StopScript() error {
 	return c.cmdWrite(c.cmdPreamble(cmdStopScript))
@@ -182,6 +454,9 @@ func (c *Controller) StopScript() error {
 
 // RestartScript restarts the servo script at a specified subroutine.
 func (c *Controller) RestartScript(subroutine uint8) error {
+	if subroutine > maxSubroutine {
+		return fmt.Errorf("subroutine %d > %d", subroutine, maxSubroutine)
+	}
 	cmd := c.cmdPreamble(cmdRestartScript)
 	cmd = append(cmd, subroutine)
 	return c.cmdWrite(cmd)
@@ -189,47 +464,191 @@ func (c *Controller) RestartScript(subroutine uint8) error {
 
 // RestartScriptParms restarts the servo script at a specified subroutine and parameter value.
 func (c *Controller) RestartScriptParms(subroutine uint8, val uint16) error {
+	if subroutine > maxSubroutine {
+		return fmt.Errorf("subroutine %d > %d", subroutine, maxSubroutine)
+	}
+	x, err := pack14(val)
+	if err != nil {
+		return err
+	}
 	cmd := c.cmdPreamble(cmdRestartScriptParms)
-	cmd = append(cmd, []byte{subroutine, lo(val), hi(val)}...)
+	cmd = append(cmd, subroutine)
+	cmd = append(cmd, x[:]...)
 	return c.cmdWrite(cmd)
 }
 
 // GetScriptStatus returns true if a servo script is running.
+// Note: the controller responds with 0x00 when the script is running and 0x01 when it has stopped.
 func (c *Controller) GetScriptStatus() (bool, error) {
-	err := c.cmdWrite(c.cmdPreamble(cmdGetScriptStatus))
+	status, err := c.GetScriptStatusRaw()
 	if err != nil {
 		return false, err
 	}
+	return !decodeBool(status), nil
+}
+
+// GetScriptStatusRaw returns the raw script status byte.
+// The Maestro documents 0x00 (running) and 0x01 (stopped), other values are returned as is.
+func (c *Controller) GetScriptStatusRaw() (uint8, error) {
 	var buf [1]byte
-	err = c.rspRead(buf[:])
+	err := c.query(c.cmdPreamble(cmdGetScriptStatus), buf[:])
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	return buf[0] == 0, nil
+	return buf[0], nil
+}
+
+// WaitScriptDone polls the script status until the script has stopped running.
+func (c *Controller) WaitScriptDone(poll, timeout time.Duration) error {
+	err := waitFor(poll, timeout, func() (bool, error) {
+		running, err := c.GetScriptStatus()
+		return !running, err
+	})
+	if err != nil {
+		return fmt.Errorf("script: %s", err)
+	}
+	return nil
+}
+
+// RunSubroutineAndWait restarts the servo script at a subroutine and waits for the script to stop.
+// The subroutine should end with a QUIT so the script stops when it has finished.
+// Note: the serial protocol can't upload a script, the script is loaded over USB.
+func (c *Controller) RunSubroutineAndWait(subroutine uint8, poll, timeout time.Duration) error {
+	err := c.RestartScript(subroutine)
+	if err != nil {
+		return err
+	}
+	return c.WaitScriptDone(poll, timeout)
 }
 
 // SetTargets sets the target value for multiple servos (starting at the referenced servo).
+// See TargetMode for the commands used.
 func (c *Controller) SetTargets(channel uint8, targets []uint16) error {
 	if len(targets) == 0 {
 		return nil
 	}
-	// build the command
-	cmd := c.cmdPreamble(cmdSetMultipleTargets)
-	cmd = append(cmd, []byte{byte(len(targets)), channel}...)
-	// check and append the target values
+	switch c.mode {
+	case TargetModeServo:
+	case TargetModeJrk:
+		return c.setJrkTargets(channel, targets)
+	default:
+		return fmt.Errorf("bad target mode %d", c.mode)
+	}
+	return c.setTargets(channel, targets, true)
+}
+
+// SetTargetsRaw sets the target value for multiple servos (starting at the referenced servo)
+// without checking the values against the servo limits. The servo channels must exist.
+// This avoids the per-value checks for trusted (e.g. precomputed) targets, but an out of
+// range value will be sent to the servo without error or clamping.
+func (c *Controller) SetTargetsRaw(channel uint8, targets []uint16) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	return c.setTargets(channel, targets, false)
+}
+
+// setTargets sends a SetMultipleTargets command, optionally checking the target values.
+func (c *Controller) setTargets(channel uint8, targets []uint16, check bool) error {
+	err := c.checkEStop()
+	if err != nil {
+		return err
+	}
+	if int(channel)+len(targets) > c.channels {
+		return fmt.Errorf("channels %d..%d exceed the %d controller channels", channel, int(channel)+len(targets)-1, c.channels)
+	}
+	// build the command arguments
+	var args [2 + 2*maxServos]byte
+	args[0] = byte(len(targets))
+	args[1] = channel
+	// check and add the target values
+	var vals [maxServos]uint16
 	for i, v := range targets {
 		ch := channel + uint8(i)
 		if ch >= maxServos || c.servo[ch] == nil {
 			return fmt.Errorf("bad servo channel %d", ch)
 		}
-		val, err := c.servo[ch].checkTarget(v)
+		err := c.checkPWM(ch)
+		if err != nil {
+			return err
+		}
+		val, out := v, v
+		if check {
+			var err error
+			val, err = c.servo[ch].checkTarget(v)
+			if err != nil {
+				return fmt.Errorf("%s for channel %d", err.Error(), ch)
+			}
+			out = c.servo[ch].trimmed(val)
+		}
+		x, err := pack14(out)
 		if err != nil {
 			return fmt.Errorf("%s for channel %d", err.Error(), ch)
 		}
-		cmd = append(cmd, []byte{lo(val), hi(val)}...)
+		vals[i] = val
+		args[2+2*i] = x[0]
+		args[3+2*i] = x[1]
+	}
+	// stop any ramps that would overwrite the targets
+	for i := range targets {
+		c.servo[channel+uint8(i)].stopSlew()
 	}
 	// send the command
-	return c.cmdWrite(cmd)
+	err = c.cmdWriteArgs(cmdSetMultipleTargets, args[:2+2*len(targets)]...)
+	if !commandSent(err) {
+		return err
+	}
+	// record the sent targets
+	for i := range targets {
+		c.servo[channel+uint8(i)].setSent(vals[i])
+	}
+	return err
+}
+
+// ServoParams are the speed and acceleration parameters for a servo channel.
+type ServoParams struct {
+	Channel      uint8        // servo channel number
+	Speed        Speed        // maximum speed (0 is no limit)
+	Acceleration Acceleration // maximum acceleration (0 is no limit)
+}
+
+// ConfigureServos sets the speed and acceleration for multiple servos.
+// The commands are validated and then sent with a single port write.
+func (c *Controller) ConfigureServos(params []ServoParams) error {
+	err := c.checkEStop()
+	if err != nil {
+		return err
+	}
+	cmds := make([][]byte, 0, 2*len(params))
+	for _, p := range params {
+		if p.Channel >= maxServos || c.servo[p.Channel] == nil {
+			return fmt.Errorf("bad servo channel %d", p.Channel)
+		}
+		s := c.servo[p.Channel]
+		speed, err := pack14(uint16(p.Speed))
+		if err != nil {
+			return fmt.Errorf("speed %s for channel %d", err.Error(), p.Channel)
+		}
+		accel, err := pack14(uint16(p.Acceleration))
+		if err != nil {
+			return fmt.Errorf("acceleration %s for channel %d", err.Error(), p.Channel)
+		}
+		cmds = append(cmds, append(s.cmdPreamble(cmdSetSpeed), speed[:]...))
+		cmds = append(cmds, append(s.cmdPreamble(cmdSetAcceleration), accel[:]...))
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	err = c.cmdWriteN(cmds)
+	if !commandSent(err) {
+		return err
+	}
+	for _, p := range params {
+		s := c.servo[p.Channel]
+		s.setSpeed(p.Speed)
+		s.setAcceleration(p.Acceleration)
+	}
+	return err
 }
 
 //-----------------------------------------------------------------------------
@@ -237,18 +656,43 @@ func (c *Controller) SetTargets(channel uint8, targets []uint16) error {
 
 // Servo is a servo motor instance.
 type Servo struct {
-	ctrl    *Controller // parent controller
-	channel uint8       // servo channel number
-	min     uint16      // minimum target position
-	max     uint16      // maximum target position
-	clamp   bool        // clamp out-of-range target values
+	ctrl     *Controller                     // parent controller
+	channel  uint8                           // servo channel number
+	min      uint16                          // minimum target position
+	max      uint16                          // maximum target position
+	clamp    bool                            // clamp out-of-range target values
+	deadband uint16                          // suppress target changes within this many ticks
+	target   uint16                          // last target value sent
+	sent     bool                            // has a target value been sent?
+	active   uint16                          // last non-zero target value sent
+	rounding Rounding                        // rounding mode for target conversions
+	home     uint16                          // host-side home position
+	homeSet  bool                            // has the home position been set?
+	onLimit  func(requested, applied uint16) // limit hit callback
+	limiter  *rateLimiter                    // rate limited target writer
+	cal      *Calibration                    // physical unit calibration
+	speed    Speed                           // last speed limit set
+	accel    Acceleration                    // last acceleration limit set
+	trim     int16                           // offset added to commanded targets
+	history  *history                        // target history (nil if disabled)
+	speedSet bool                            // has the speed limit been set?
+	accelSet bool                            // has the acceleration limit been set?
+	posCheck *PositionCheck                  // position plausibility check (nil if disabled)
+	jog      *jogger                         // jog goroutine (nil if not jogging)
+	slew     float64                         // maximum target slew rate (ticks/second, 0 is no limit)
+	slewer   *slewer                         // slew rate limited target ramp
+	wdOffs   uint32                          // watchdog turn off count at the last target sent
 }
 
 // NewServo returns a new servo motor instance.
+// If a servo has already been created for the channel it is returned unchanged.
 func (c *Controller) NewServo(channel uint8) (*Servo, error) {
 	if channel >= maxServos {
 		return nil, fmt.Errorf("bad servo channel %d", channel)
 	}
+	if c.servo[channel] != nil {
+		return c.servo[channel], nil
+	}
 	s := &Servo{
 		ctrl:    c,
 		channel: channel,
@@ -257,9 +701,35 @@ func (c *Controller) NewServo(channel uint8) (*Servo, error) {
 		clamp:   false,
 	}
 	c.servo[channel] = s
+	c.wd.addServo(channel)
 	return s, nil
 }
 
+// GetServo returns the servo for a channel (nil if no servo has been created).
+func (c *Controller) GetServo(channel uint8) *Servo {
+	if channel >= maxServos {
+		return nil
+	}
+	return c.servo[channel]
+}
+
+// Channel returns the servo channel number.
+func (s *Servo) Channel() uint8 {
+	return s.channel
+}
+
+// Controller returns the parent controller of the servo.
+func (s *Servo) Controller() *Controller {
+	return s.ctrl
+}
+
+func absDiff(a, b uint16) uint16 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
 func lo(x uint16) byte {
 	return byte(x & 0x7f)
 }
@@ -268,6 +738,29 @@ func hi(x uint16) byte {
 	return byte((x >> 7) & 0x7f)
 }
 
+// Data bytes sent to the controller must have the high bit clear, so 14-bit
+// values are sent as two 7-bit bytes (see pack14). Responses from the
+// controller (positions, errors, jrk variables) are raw 8-bit bytes.
+
+// decode8 decodes a 16-bit value from two raw response bytes (low byte first).
+func decode8(lo, hi byte) uint16 {
+	return uint16(lo) | uint16(hi)<<8
+}
+
+// decodeBool decodes a single byte 0x00/0x01 response.
+// Only bit 0 is significant, so a stray high bit can't change the result.
+func decodeBool(x byte) bool {
+	return x&1 != 0
+}
+
+// pack14 packs a 14-bit value into two 7-bit data bytes (low bits first).
+func pack14(x uint16) ([2]byte, error) {
+	if x > maxTarget {
+		return [2]byte{}, fmt.Errorf("value %d > %d (14-bit maximum)", x, maxTarget)
+	}
+	return [2]byte{lo(x), hi(x)}, nil
+}
+
 func (s *Servo) cmdPreamble(command uint8) []byte {
 	if s.ctrl.compact {
 		return []byte{command, s.channel}
@@ -275,30 +768,51 @@ func (s *Servo) cmdPreamble(command uint8) []byte {
 	return []byte{0xaa, s.ctrl.device, command & 0x7f, s.channel}
 }
 
+// clampTarget clamps the target value to the servo limits.
+func (s *Servo) clampTarget(target uint16) uint16 {
+	applied := target
+	if target < s.min {
+		applied = s.min
+	}
+	if target > s.max {
+		applied = s.max
+	}
+	if applied != target && s.onLimit != nil {
+		s.onLimit(target, applied)
+	}
+	return applied
+}
+
 // checkTarget clamps/limits the servo target value
 func (s *Servo) checkTarget(target uint16) (uint16, error) {
 	if s.clamp {
-		if target < s.min {
-			return s.min, nil
-		}
-		if target > s.max {
-			return s.max, nil
-		}
-	} else {
-		if target < s.min {
-			return s.min, errors.New("target too low")
-		}
-		if target > s.max {
-			return s.max, errors.New("target too high")
-		}
+		return s.clampTarget(target), nil
+	}
+	if target < s.min {
+		return s.min, errors.New("target too low")
+	}
+	if target > s.max {
+		return s.max, errors.New("target too high")
 	}
 	return target, nil
 }
 
+// SetClamp sets the servo to clamp out-of-range target values to the
+// servo limits (true) or to return an error for them (false).
+func (s *Servo) SetClamp(clamp bool) {
+	s.clamp = clamp
+}
+
+// OnLimit sets a callback function that is called when a clamped target value
+// is changed to fit the servo limits.
+func (s *Servo) OnLimit(fn func(requested, applied uint16)) {
+	s.onLimit = fn
+}
+
 // SetLimits sets the minimum/maximum values for the servo target position.
 func (s *Servo) SetLimits(min, max uint16) error {
-	if max > min {
-		return errors.New("max > min")
+	if min > max {
+		return errors.New("min > max")
 	}
 	if min > maxTarget {
 		return fmt.Errorf("min > %d", maxTarget)
@@ -311,50 +825,370 @@ func (s *Servo) SetLimits(min, max uint16) error {
 	return nil
 }
 
+// SetDeadband sets the servo deadband. A SetTarget within the deadband
+// of the last sent target is suppressed (0 disables the deadband).
+func (s *Servo) SetDeadband(ticks uint16) {
+	s.deadband = ticks
+}
+
+// inDeadband returns true if the target is within the deadband of the last sent target.
+func (s *Servo) inDeadband(target uint16) bool {
+	if s.deadband == 0 || !s.sent || s.offByWatchdog() {
+		return false
+	}
+	return absDiff(target, s.target) <= s.deadband
+}
+
+// SetTrim sets an offset that is added to each commanded target (after the
+// limit check) to adjust the neutral point of the servo. The trimmed target is
+// clamped to the servo limits. Zero (off) targets and SetTargetsRaw are not trimmed.
+func (s *Servo) SetTrim(offset int16) {
+	s.trim = offset
+}
+
+// Trim returns the servo trim offset.
+func (s *Servo) Trim() int16 {
+	return s.trim
+}
+
+// trimmed returns the target value with the trim offset applied.
+func (s *Servo) trimmed(target uint16) uint16 {
+	if target == 0 || s.trim == 0 {
+		return target
+	}
+	t := int(target) + int(s.trim)
+	if t < int(s.min) {
+		t = int(s.min)
+	}
+	if t > int(s.max) {
+		t = int(s.max)
+	}
+	return uint16(t)
+}
+
+// setSent records the last target value sent to the servo.
+func (s *Servo) setSent(target uint16) {
+	s.target = target
+	s.sent = true
+	if s.history != nil {
+		s.history.add(target)
+	}
+	if target != 0 {
+		s.active = target
+	}
+	s.wdOffs = atomic.LoadUint32(&s.ctrl.wd.offs)
+	if s.ctrl.onTarget != nil {
+		s.ctrl.onTarget(s.channel, target)
+	}
+}
+
 // SetTarget sets the servo target value.
+// With a maximum slew rate (see SetMaxSlew) the target is ramped to the value.
 func (s *Servo) SetTarget(target uint16) error {
 	target, err := s.checkTarget(target)
 	if err != nil {
 		return err
 	}
-	cmd := s.cmdPreamble(cmdSetTarget)
-	cmd = append(cmd, []byte{lo(target), hi(target)}...)
-	return s.ctrl.cmdWrite(cmd)
+	if s.slew != 0 {
+		return s.slewTo(target)
+	}
+	if s.inDeadband(target) {
+		return nil
+	}
+	return s.writeTarget(target)
+}
+
+// SetTargetClamped sets the servo target value, clamped to the servo limits regardless
+// of the clamp setting. It returns the target value applied (before any trim offset).
+// If the target is within the deadband the last target sent is returned.
+// With a maximum slew rate (see SetMaxSlew) the target is ramped to the value.
+func (s *Servo) SetTargetClamped(target uint16) (uint16, error) {
+	target = s.clampTarget(target)
+	if s.slew != 0 {
+		err := s.slewTo(target)
+		if err != nil {
+			return 0, err
+		}
+		return target, nil
+	}
+	if s.inDeadband(target) {
+		return s.target, nil
+	}
+	err := s.writeTarget(target)
+	if err != nil {
+		return 0, err
+	}
+	return target, nil
+}
+
+// SetTargetAsync sets the servo target value on a goroutine and returns a channel
+// that receives the SetTarget result. Writes to the serial port are serialized, but
+// the order of concurrent writes isn't defined, so receive the result before making
+// another target write for this servo.
+func (s *Servo) SetTargetAsync(target uint16) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.SetTarget(target)
+	}()
+	return done
+}
+
+// writeTarget writes a target value to the servo (stopping any slew rate limited ramp).
+func (s *Servo) writeTarget(target uint16) error {
+	s.stopSlew()
+	return s.sendTarget(target)
+}
+
+// sendTarget writes a target value to the servo.
+func (s *Servo) sendTarget(target uint16) error {
+	if target != 0 {
+		err := s.ctrl.checkEStop()
+		if err != nil {
+			return err
+		}
+	}
+	err := s.ctrl.checkPWM(s.channel)
+	if err != nil {
+		return err
+	}
+	x, err := pack14(s.trimmed(target))
+	if err != nil {
+		return err
+	}
+	err = s.ctrl.cmdWriteArgs(cmdSetTarget, s.channel, x[0], x[1])
+	if !commandSent(err) {
+		return err
+	}
+	s.setSent(target)
+	return err
+}
+
+// Disable stops the servo control pulses (a target value of 0).
+func (s *Servo) Disable() error {
+	return s.writeTarget(0)
+}
+
+// Enable restarts the servo control pulses with the last non-zero target value.
+func (s *Servo) Enable() error {
+	if s.active == 0 {
+		return fmt.Errorf("channel %d: no target to enable", s.channel)
+	}
+	return s.writeTarget(s.active)
+}
+
+// Enabled returns true if the last target value sent to the servo was non-zero.
+func (s *Servo) Enabled() bool {
+	return s.sent && s.target != 0 && !s.offByWatchdog()
 }
 
 // SetSpeed sets the servo maximum speed (0 is no limit).
-func (s *Servo) SetSpeed(speed uint16) error {
-	cmd := s.cmdPreamble(cmdSetSpeed)
-	cmd = append(cmd, []byte{lo(speed), hi(speed)}...)
-	return s.ctrl.cmdWrite(cmd)
+func (s *Servo) SetSpeed(speed Speed) error {
+	err := s.ctrl.checkEStop()
+	if err != nil {
+		return err
+	}
+	x, err := pack14(uint16(speed))
+	if err != nil {
+		return err
+	}
+	err = s.ctrl.cmdWriteArgs(cmdSetSpeed, s.channel, x[0], x[1])
+	if !commandSent(err) {
+		return err
+	}
+	s.setSpeed(speed)
+	return err
 }
 
 // SetAcceleration sets the servo maximum acceleration (0 is no limit).
-func (s *Servo) SetAcceleration(acceleration uint16) error {
-	cmd := s.cmdPreamble(cmdSetAcceleration)
-	cmd = append(cmd, []byte{lo(acceleration), hi(acceleration)}...)
-	return s.ctrl.cmdWrite(cmd)
+func (s *Servo) SetAcceleration(acceleration Acceleration) error {
+	err := s.ctrl.checkEStop()
+	if err != nil {
+		return err
+	}
+	x, err := pack14(uint16(acceleration))
+	if err != nil {
+		return err
+	}
+	err = s.ctrl.cmdWriteArgs(cmdSetAcceleration, s.channel, x[0], x[1])
+	if !commandSent(err) {
+		return err
+	}
+	s.setAcceleration(acceleration)
+	return err
 }
 
-// SetPWM sets the ontime and period for a servo control signal.
+// setSpeed records the speed limit sent to the controller.
+func (s *Servo) setSpeed(speed Speed) {
+	s.speed = speed
+	s.speedSet = true
+}
+
+// setAcceleration records the acceleration limit sent to the controller.
+func (s *Servo) setAcceleration(acceleration Acceleration) {
+	s.accel = acceleration
+	s.accelSet = true
+}
+
+// Speed returns the last speed limit set for the servo.
+// The controller can't report its speed setting, so this is 0 if no speed limit has been set.
+func (s *Servo) Speed() Speed {
+	return s.speed
+}
+
+// Acceleration returns the last acceleration limit set for the servo.
+// The controller can't report its acceleration setting, so this is 0 if no acceleration limit has been set.
+func (s *Servo) Acceleration() Acceleration {
+	return s.accel
+}
+
+// SetPWM sets the on time and period of the controller PWM output.
+//
+// Deprecated: The PWM output is a controller function, use Controller.EnablePWM.
 func (s *Servo) SetPWM(ontime, period uint16) error {
-	cmd := s.cmdPreamble(cmdSetPWM)
-	cmd = append(cmd, []byte{lo(ontime), hi(ontime), lo(period), hi(period)}...)
-	return s.ctrl.cmdWrite(cmd)
+	return s.ctrl.EnablePWM(ontime, period)
+}
+
+// PositionCheck validates positions read from the controller against the servo limits.
+type PositionCheck struct {
+	Margin  uint16 // allowed ticks outside of the servo min..max range
+	Retries int    // number of re-reads of an implausible position
+}
+
+// SetPositionCheck sets the position plausibility check used by GetPosition (nil disables the check).
+// A position outside the servo min..max range (plus margin) can't be physically reached, so it is
+// assumed to be a corrupted response and the position is read again. A position of 0 (no pulses) is valid.
+func (s *Servo) SetPositionCheck(pc *PositionCheck) {
+	if pc == nil {
+		s.posCheck = nil
+		return
+	}
+	x := *pc
+	s.posCheck = &x
+}
+
+// plausible returns true if a position read from the controller is plausible.
+func (s *Servo) plausible(pos uint16) bool {
+	if pos == 0 {
+		return true
+	}
+	m := int(s.posCheck.Margin)
+	return int(pos) >= int(s.min)-m && int(pos) <= int(s.max)+m
+}
+
+// checkPosition reads the position until it is plausible (if a position check is set).
+func (s *Servo) checkPosition(read func() (uint16, error)) (uint16, error) {
+	pos, err := read()
+	if err != nil || s.posCheck == nil {
+		return pos, err
+	}
+	for i := 0; !s.plausible(pos); i++ {
+		if i >= s.posCheck.Retries {
+			return 0, fmt.Errorf("channel %d: implausible position %d", s.channel, pos)
+		}
+		pos, err = read()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return pos, nil
 }
 
 // GetPosition returns the current commanded position for the servo.
+// If a position check is set (for this and the other position reads),
+// implausible positions are read again.
 func (s *Servo) GetPosition() (uint16, error) {
-	err := s.ctrl.cmdWrite(s.cmdPreamble(cmdGetPosition))
-	if err != nil {
-		return 0, err
+	cmd := s.cmdPreamble(cmdGetPosition)
+	return s.checkPosition(func() (uint16, error) {
+		return s.ctrl.query16(cmd)
+	})
+}
+
+// GetPositionsRange returns the current commanded positions for count channels starting
+// at a channel. The Maestro has no bulk position read, so a GetPosition query is made for
+// each channel with the serial link held for the whole range. On a read failure the returned
+// slice has the positions read before the failure (the rest are 0) and the error is returned.
+func (c *Controller) GetPositionsRange(channel uint8, count int) ([]uint16, error) {
+	if count < 1 || int(channel)+count > c.channels {
+		return nil, fmt.Errorf("bad channel range %d..%d", channel, int(channel)+count-1)
 	}
+	pos := make([]uint16, count)
+	cmd := append(c.cmdPreamble(cmdGetPosition), channel)
 	var buf [2]byte
-	err = s.ctrl.rspRead(buf[:])
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	read := func() (uint16, error) {
+		_, err := c.queryLocked(cmd, buf[:])
+		if err != nil {
+			return 0, err
+		}
+		return decode8(buf[0], buf[1]), nil
+	}
+	for i := range pos {
+		ch := channel + uint8(i)
+		cmd[len(cmd)-1] = ch
+		var x uint16
+		var err error
+		if s := c.servo[ch]; s != nil {
+			x, err = s.checkPosition(read)
+		} else {
+			x, err = read()
+		}
+		if err != nil {
+			return pos, fmt.Errorf("channel %d: %w", ch, err)
+		}
+		pos[i] = x
+	}
+	return pos, nil
+}
+
+// GetPositionContext returns the current commanded position for the servo.
+// The context deadline is used as the response read deadline if the port
+// supports read deadlines (see ReadDeadliner), otherwise the read timeout is used.
+func (s *Servo) GetPositionContext(ctx context.Context) (uint16, error) {
+	err := ctx.Err()
 	if err != nil {
 		return 0, err
 	}
-	return uint16(buf[0]) + uint16(buf[1])<<8, nil
+	deadline, _ := ctx.Deadline()
+	cmd := s.cmdPreamble(cmdGetPosition)
+	return s.checkPosition(func() (uint16, error) {
+		var buf [2]byte
+		err := s.ctrl.queryDeadline(cmd, buf[:], deadline)
+		if err != nil {
+			return 0, err
+		}
+		return decode8(buf[0], buf[1]), nil
+	})
+}
+
+// GetPositionTimed returns the current commanded position for the servo and the
+// time at which it was read (after the response was received).
+func (s *Servo) GetPositionTimed() (uint16, time.Time, error) {
+	cmd := s.cmdPreamble(cmdGetPosition)
+	var t time.Time
+	pos, err := s.checkPosition(func() (uint16, error) {
+		var buf [2]byte
+		var err error
+		t, err = s.ctrl.queryTimed(cmd, buf[:])
+		if err != nil {
+			return 0, err
+		}
+		return decode8(buf[0], buf[1]), nil
+	})
+	return pos, t, err
+}
+
+// GetLogicalPosition returns the current commanded position for the servo with the trim offset removed.
+func (s *Servo) GetLogicalPosition() (uint16, error) {
+	pos, err := s.GetPosition()
+	if err != nil || pos == 0 {
+		return pos, err
+	}
+	p := int(pos) - int(s.trim)
+	if p < 0 {
+		p = 0
+	}
+	return uint16(p), nil
 }
 
 //-----------------------------------------------------------------------------
@@ -10,7 +10,16 @@ See: https://www.pololu.com/docs/pdf/0J40/maestro.pdf
 
 package sc
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deadsy/maestro/sc/sctest"
+)
 
 //-----------------------------------------------------------------------------
 
@@ -21,4 +30,2795 @@ func TestCrc(t *testing.T) {
 	}
 }
 
+// crc7Ref is the bitwise crc-7 algorithm given in the Pololu documentation.
+func crc7Ref(buf []byte) uint8 {
+	var crc uint8
+	for _, v := range buf {
+		crc ^= v
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc ^= crc7Poly
+			}
+			crc >>= 1
+		}
+	}
+	return crc
+}
+
+func TestCrcKnownAnswer(t *testing.T) {
+	tests := []struct {
+		buf []byte
+		crc uint8
+	}{
+		{[]byte{}, 0x00},
+		{[]byte{0x83, 0x01}, 0x17},
+		{[]byte{0x84, 0x01, 0x23, 0x45}, 0x55},
+		{[]byte{0xaa, 0x0c, 0x04, 0x00, 0x70, 0x2e}, 0x22},
+		{[]byte{0xff}, 0x4f},
+	}
+	for _, v := range tests {
+		crc := crc7(0, v.buf)
+		if crc != v.crc {
+			t.Errorf("% x: expected 0x%02x, got 0x%02x", v.buf, v.crc, crc)
+		}
+	}
+	// crc accumulation across buffers
+	if crc7(crc7(0, []byte{0x84, 0x01}), []byte{0x23, 0x45}) != 0x55 {
+		t.Error("accumulated crc failed")
+	}
+}
+
+func TestCrcTable(t *testing.T) {
+	// single bytes exercise every table entry
+	for i := 0; i < 256; i++ {
+		buf := []byte{byte(i)}
+		if crc7(0, buf) != crc7Ref(buf) {
+			t.Errorf("0x%02x: table mismatch", i)
+		}
+	}
+	// multi-byte messages
+	buf := []byte{}
+	for i := 0; i < 64; i++ {
+		buf = append(buf, byte(i*37+11))
+		if crc7(0, buf) != crc7Ref(buf) {
+			t.Errorf("length %d: mismatch", len(buf))
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// newTestController returns a compact protocol controller on a recording port.
+func newTestController(t *testing.T) (*Controller, *sctest.Port) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	return c, port
+}
+
+//-----------------------------------------------------------------------------
+
+func TestDeadband(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetDeadband(10)
+
+	tests := []struct {
+		target uint16
+		write  bool
+	}{
+		{6000, true},  // first write is never suppressed
+		{6000, false}, // same value
+		{6010, false}, // within deadband
+		{5990, false}, // within deadband
+		{6011, true},  // outside deadband
+		{6005, false}, // within deadband of new value
+		{5990, true},  // outside deadband
+	}
+
+	for i, v := range tests {
+		err := s.SetTarget(v.target)
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		written := len(port.Written()) != 0
+		if written != v.write {
+			t.Errorf("test %d: target %d, expected write %v, got %v", i, v.target, v.write, written)
+		}
+		port.Reset()
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestScriptStatus(t *testing.T) {
+	c, port := newTestController(t)
+
+	// 0x00 is running, 0x01 is stopped
+	port.QueueResponse(0x00, 0x01)
+	running, err := c.GetScriptStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !running {
+		t.Error("0x00 should be running")
+	}
+	running, err = c.GetScriptStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running {
+		t.Error("0x01 should be stopped")
+	}
+
+	// running, running, stopped
+	port.QueueResponse(0x00, 0x00, 0x01)
+	err = c.WaitScriptDone(time.Millisecond, time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if port.Pending() != 0 {
+		t.Error("expected all status responses to be read")
+	}
+
+	// always running
+	port.QueueResponse(make([]byte, 100)...)
+	err = c.WaitScriptDone(time.Millisecond, 5*time.Millisecond)
+	if err == nil {
+		t.Error("expected a timeout error")
+	}
+
+	// raw status
+	port.Reset()
+	port.QueueResponse(0x81)
+	status, err := c.GetScriptStatusRaw()
+	if err != nil || status != 0x81 {
+		t.Errorf("raw status 0x%02x, %v", status, err)
+	}
+
+	// run a subroutine: running, stopped
+	port.Reset()
+	port.QueueResponse(0x00, 0x01)
+	err = c.RunSubroutineAndWait(3, time.Millisecond, time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	port.AssertFrame(t, []byte{0xa7, 3})
+}
+
+//-----------------------------------------------------------------------------
+
+func TestJrkSetTarget(t *testing.T) {
+	port := sctest.NewPort()
+	j, err := NewJrkController(&Config{Port: port, DeviceNumber: 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	// example from the jrk user guide: target 3229 on device 11
+	err = j.SetTargetHighResolution(3229)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 0x0b, 0x5d, 0x64})
+	if j.SetTargetHighResolution(4096) == nil {
+		t.Error("expected an error for target > 4095")
+	}
+}
+
+func TestJrkGetError(t *testing.T) {
+	port := sctest.NewPort()
+	j, err := NewJrkController(&Config{Port: port, DeviceNumber: 11, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// variables are raw 16-bit values (low byte first)
+	port.QueueResponse(0xd0, 0x07)
+	x, err := j.GetFeedback()
+	if err != nil || x != 2000 {
+		t.Errorf("feedback %d (%v), expected 2000", x, err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 0x0b, cmdGetFeedback & 0x7f})
+	port.QueueResponse(0x34, 0x08)
+	x, err = j.GetTarget()
+	if err != nil || x != 2100 {
+		t.Errorf("target %d (%v), expected 2100", x, err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 0x0b, cmdGetTarget & 0x7f})
+	// the error is scaled feedback - target
+	port.QueueResponse(0xd0, 0x07, 0x34, 0x08)
+	e, err := j.GetError()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e != -100 {
+		t.Errorf("error %d, expected -100", e)
+	}
+	port.AssertFrame(t, []byte{0xaa, 0x0b, cmdGetScaledFeedback & 0x7f})
+	port.AssertFrame(t, []byte{0xaa, 0x0b, cmdGetTarget & 0x7f})
+	port.AssertNoFrames(t)
+	// no target response
+	port.QueueResponse(0xd0, 0x07)
+	if _, err := j.GetError(); err == nil {
+		t.Error("expected an error for no response")
+	}
+}
+
+func TestJrkGetVariables(t *testing.T) {
+	port := sctest.NewPort()
+	j, err := NewJrkController(&Config{Port: port, Compact: true, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.QueueResponse(
+		0x64, 0x00, // input 100
+		0x00, 0x08, // target 2048
+		0xe8, 0x03, // feedback 1000
+		0xd0, 0x07, // scaled feedback 2000
+		0xfb, 0xff, // error sum -5
+		0xa8, 0xfd, // duty cycle target -600
+		0x2c, 0x01, // duty cycle 300
+		42, // current (1 byte)
+	)
+	v, err := j.GetVariables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := JrkVariables{100, 2048, 1000, 2000, -5, -600, 300, 42}
+	if *v != expected {
+		t.Errorf("variables %+v, expected %+v", *v, expected)
+	}
+	for _, cmd := range []byte{cmdGetInput, cmdGetTarget, cmdGetFeedback, cmdGetScaledFeedback,
+		cmdGetErrorSum, cmdGetDutyCycleTarget, cmdGetDutyCycle, cmdGetCurrent} {
+		port.AssertFrame(t, []byte{cmd})
+	}
+	port.AssertNoFrames(t)
+	if port.Pending() != 0 {
+		t.Errorf("%d response bytes not read", port.Pending())
+	}
+	// a short response fails the read
+	port.QueueResponse(0x64, 0x00, 0x00)
+	if _, err := j.GetVariables(); err == nil {
+		t.Error("expected an error for a short response")
+	}
+}
+
+func TestJrkAutoCheckErrors(t *testing.T) {
+	port := sctest.NewPort()
+	_, err := NewJrkController(&Config{Port: port, DeviceNumber: 11, AutoCheckErrors: true, InitAction: InitNone})
+	if err == nil {
+		t.Error("expected an error for AutoCheckErrors on a jrk")
+	}
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestPack14(t *testing.T) {
+	tests := []struct {
+		val uint16
+		rsp [2]byte
+		err bool
+	}{
+		{0, [2]byte{0x00, 0x00}, false},
+		{0x7f, [2]byte{0x7f, 0x00}, false},
+		{0x80, [2]byte{0x00, 0x01}, false},
+		{6000, [2]byte{0x70, 0x2e}, false},
+		{0x3fff, [2]byte{0x7f, 0x7f}, false},
+		{0x4000, [2]byte{}, true},
+		{0xffff, [2]byte{}, true},
+	}
+	for _, v := range tests {
+		x, err := pack14(v.val)
+		if (err != nil) != v.err {
+			t.Errorf("%d: expected error %v, got %v", v.val, v.err, err)
+		}
+		if x != v.rsp {
+			t.Errorf("%d: expected % x, got % x", v.val, v.rsp, x)
+		}
+	}
+
+	// overflowing values must not be written
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if s.SetSpeed(0x4000) == nil || s.SetAcceleration(0x4000) == nil || s.SetPWM(0x4000, 0) == nil {
+		t.Error("expected an error for value > 0x3fff")
+	}
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestDecode(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+
+	// positions are full 8-bit bytes
+	port.QueueResponse(0xff, 0x17)
+	pos, err := s.GetPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 0x17ff {
+		t.Errorf("expected position 0x17ff, got 0x%04x", pos)
+	}
+
+	// errors are full 8-bit bytes (bit 7 must not be masked)
+	port.QueueResponse(0x80, 0x01)
+	code, err := c.GetErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0x0180 {
+		t.Errorf("expected errors 0x0180, got 0x%04x", code)
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestUnits(t *testing.T) {
+	// 0.25us/10ms == 25us/s
+	if SpeedFromMicrosPerSec(25) != 1 || SpeedFromMicrosPerSec(1000) != 40 {
+		t.Error("bad speed conversion")
+	}
+	if Speed(40).MicrosPerSec() != 1000 {
+		t.Error("bad speed conversion")
+	}
+	// 0.25us/10ms/80ms == 312.5us/s/s
+	if AccelerationFromMicrosPerSecSq(312.5) != 1 || AccelerationFromMicrosPerSecSq(3125) != 10 {
+		t.Error("bad acceleration conversion")
+	}
+	if Acceleration(10).MicrosPerSecSq() != 3125 {
+		t.Error("bad acceleration conversion")
+	}
+	if SpeedFromMicrosPerSec(-1) != 0 {
+		t.Error("negative speed should be 0")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestNilPort(t *testing.T) {
+	_, err := NewController(&Config{})
+	if err == nil {
+		t.Error("expected an error for a nil port")
+	}
+	_, err = NewJrkController(&Config{})
+	if err == nil {
+		t.Error("expected an error for a nil port")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestInitAction(t *testing.T) {
+	port := sctest.NewPort()
+	_, err := NewController(&Config{Port: port, Compact: true, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertNoFrames(t)
+
+	port.QueueResponse(0x00, 0x00)
+	_, err = NewController(&Config{Port: port, Compact: true, InitAction: InitClearErrors})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdGetErrors})
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestJrkTargetMode(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, DeviceNumber: 1, TargetMode: TargetModeJrk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	err = c.SetTargets(11, []uint16{3229, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 0x0b, 0x5d, 0x64, 0xaa, 0x0c, 0x40, 0x00})
+	if c.SetTargets(11, []uint16{4096}) == nil {
+		t.Error("expected an error for target > 4095")
+	}
+	port.AssertNoFrames(t)
+	// no jrk targets are sent after an emergency stop
+	err = c.TriggerEStop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(c.SetTargets(11, []uint16{3229, 0}), ErrEStopped) {
+		t.Error("expected an emergency stop error")
+	}
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestStats(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetTarget(6000)
+	port.QueueResponse(0x08, 0x00, 0x01)
+	c.GetErrors()
+	s.GetPosition()
+	expect := Stats{
+		Writes:     4, // auto baud, set target, get errors, get position
+		Reads:      1,
+		ShortReads: 1,
+		CrcErrors:  1,
+		Errors:     1,
+	}
+	if c.Stats() != expect {
+		t.Errorf("expected %+v, got %+v", expect, c.Stats())
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestCrcRead(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true, CrcRead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	port.Reset()
+
+	// good response crc
+	rsp := []byte{0x70, 0x17}
+	port.QueueResponse(append(rsp, crc7(0, rsp))...)
+	pos, err := s.GetPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 0x1770 {
+		t.Errorf("expected position 0x1770, got 0x%04x", pos)
+	}
+	// outgoing commands have no crc
+	port.AssertFrame(t, []byte{cmdGetPosition, 0x00})
+
+	// bad response crc
+	port.QueueResponse(0x70, 0x17, 0x00)
+	_, err = s.GetPosition()
+	if err == nil {
+		t.Error("expected a response crc error")
+	}
+	if c.Stats().RspErrors != 1 {
+		t.Error("expected a response crc error count")
+	}
+
+	// GetErrors is retried once on a bad response crc
+	port.Reset()
+	rsp = []byte{0x08, 0x00}
+	port.QueueResponse(0x01, 0x00, 0x00)
+	port.QueueResponse(append(rsp, crc7(0, rsp))...)
+	code, err := c.GetErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0x0008 {
+		t.Errorf("expected error code 0x0008, got 0x%04x", code)
+	}
+	port.QueueResponse(0x01, 0x00, 0x00, 0x01, 0x00, 0x00)
+	if c.ClearErrors() == nil {
+		t.Error("expected a response crc error")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestEnable(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if s.Enabled() || s.Enable() == nil {
+		t.Error("servo should start disabled with nothing to enable")
+	}
+	s.SetTarget(6000)
+	if !s.Enabled() {
+		t.Error("servo should be enabled")
+	}
+	port.Reset()
+	s.Disable()
+	if s.Enabled() {
+		t.Error("servo should be disabled")
+	}
+	port.AssertFrame(t, []byte{cmdSetTarget, 0x00, 0x00, 0x00})
+	s.Enable()
+	if !s.Enabled() {
+		t.Error("servo should be enabled")
+	}
+	port.AssertFrame(t, []byte{cmdSetTarget, 0x00, 0x70, 0x2e})
+}
+
+//-----------------------------------------------------------------------------
+
+func TestRounding(t *testing.T) {
+	c, _ := newTestController(t)
+	s, _ := c.NewServo(0)
+	tests := []struct {
+		mode Rounding
+		us   float64
+		val  uint16
+	}{
+		{RoundNearest, 1499.9, 6000},
+		{RoundFloor, 1499.9, 5999},
+		{RoundCeil, 1499.9, 6000},
+		{RoundNearest, 1500.1, 6000},
+		{RoundFloor, 1500.1, 6000},
+		{RoundCeil, 1500.1, 6001},
+	}
+	for _, v := range tests {
+		s.SetRounding(v.mode)
+		val := s.MicrosToTarget(v.us)
+		if val != v.val {
+			t.Errorf("mode %d, %gus: expected %d, got %d", v.mode, v.us, v.val, val)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestMoveToContext(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetTarget(4000)
+	port.Reset()
+
+	err := s.MoveTo(5000, 4*time.Millisecond, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(port.Frames()) != 4 {
+		t.Errorf("expected 4 steps, got %d", len(port.Frames()))
+	}
+	port.Reset()
+
+	// cancel after the first step
+	ctx, cancel := context.WithCancel(context.Background())
+	c.OnTargetSet(func(channel uint8, target uint16) {
+		cancel()
+	})
+	err = s.MoveToContext(ctx, 6000, time.Second, 10)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if len(port.Frames()) != 1 {
+		t.Errorf("expected 1 step, got %d", len(port.Frames()))
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestDecodeErrors(t *testing.T) {
+	codes := DecodeErrors(0x0189)
+	expect := []ErrorCode{ErrSerialSignal, ErrSerialCrc, ErrScriptCallStack, ErrScriptPC}
+	if len(codes) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, codes)
+	}
+	for i := range codes {
+		if codes[i] != expect[i] {
+			t.Errorf("expected %v, got %v", expect, codes)
+		}
+	}
+	if len(DecodeErrors(0)) != 0 || GetError(0) != nil {
+		t.Error("expected no errors")
+	}
+	if GetError(0x0009).Error() != "serial signal error,serial crc error" {
+		t.Error("bad error string")
+	}
+
+	c, port := newTestController(t)
+	port.QueueResponse(0x08, 0x00)
+	code, err := c.CheckErrors()
+	var cerr *ControllerError
+	if code != 0x0008 || !errors.As(err, &cerr) || !cerr.Has(ErrSerialCrc) {
+		t.Errorf("bitmap 0x%04x, error %v", code, err)
+	}
+	port.QueueResponse(0x00, 0x00)
+	code, err = c.CheckErrors()
+	if code != 0 || err != nil {
+		t.Errorf("bitmap 0x%04x, error %v", code, err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestConfigureServos(t *testing.T) {
+	c, port := newTestController(t)
+	s1, _ := c.NewServo(1)
+	s3, _ := c.NewServo(3)
+	err := c.ConfigureServos([]ServoParams{
+		{Channel: 1, Speed: 140, Acceleration: 4},
+		{Channel: 3, Speed: 0, Acceleration: 255},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all the commands are sent with a single write
+	port.AssertFrame(t, []byte{
+		cmdSetSpeed, 1, 0x0c, 0x01, cmdSetAcceleration, 1, 0x04, 0x00,
+		cmdSetSpeed, 3, 0x00, 0x00, cmdSetAcceleration, 3, 0x7f, 0x01,
+	})
+	port.AssertNoFrames(t)
+	if s1.Speed() != 140 || s1.Acceleration() != 4 || s3.Speed() != 0 || s3.Acceleration() != 255 {
+		t.Error("servo parameters not recorded")
+	}
+	// nothing is sent if any parameters are bad
+	if c.ConfigureServos([]ServoParams{{Channel: 1, Speed: 10}, {Channel: 2, Speed: 10}}) == nil {
+		t.Error("expected an error for an unconfigured channel")
+	}
+	if c.ConfigureServos([]ServoParams{{Channel: 1, Speed: 10}, {Channel: 3, Speed: maxTarget + 1}}) == nil {
+		t.Error("expected an error for a bad speed")
+	}
+	port.AssertNoFrames(t)
+	if s1.Speed() != 140 {
+		t.Error("servo parameters changed by a failed configuration")
+	}
+}
+
+func TestStep(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	// no target sent, so the step is from the current position
+	port.QueueResponse(0x70, 0x17)
+	err := s.Step(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdGetPosition, 0})
+	port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(6100), hi(6100)})
+	// then from the last target sent, clamped to the limits
+	s.SetLimits(5000, 6200)
+	for _, v := range []struct {
+		delta  int16
+		target uint16
+	}{
+		{-200, 5900},
+		{500, 6200},
+		{-2000, 5000},
+	} {
+		err := s.Step(v.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(v.target), hi(v.target)})
+	}
+	port.AssertNoFrames(t)
+	// the position read has the trim removed (and the trim is added to the target)
+	s, _ = c.NewServo(1)
+	s.SetTrim(100)
+	port.QueueResponse(0xd4, 0x17)
+	err = s.Step(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdGetPosition, 1})
+	port.AssertFrame(t, []byte{cmdSetTarget, 1, lo(6110), hi(6110)})
+	if s.target != 6010 {
+		t.Errorf("target %d, expected 6010", s.target)
+	}
+	// position read error
+	s, _ = c.NewServo(2)
+	if s.Step(10) == nil {
+		t.Error("expected an error for no response")
+	}
+}
+
+func TestSafeHome(t *testing.T) {
+	c, port := newTestController(t)
+	// no errors
+	port.QueueResponse(0x00, 0x00)
+	err := c.SafeHome()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdGetErrors})
+	port.AssertFrame(t, []byte{cmdGoHome})
+	port.AssertNoFrames(t)
+	// the auto baud byte is re-sent after errors
+	port.QueueResponse(byte(ErrSerialProtocol), 0x00)
+	err = c.SafeHome()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdGetErrors})
+	port.AssertFrame(t, []byte{0xaa})
+	port.AssertFrame(t, []byte{cmdGoHome})
+	port.AssertNoFrames(t)
+	// no response
+	if c.SafeHome() == nil {
+		t.Error("expected an error for no response")
+	}
+	port.AssertFrame(t, []byte{cmdGetErrors})
+	port.AssertNoFrames(t)
+}
+
+func TestGoHomeServos(t *testing.T) {
+	c, port := newTestController(t)
+	servos := []*Servo{}
+	for _, ch := range []uint8{1, 2, 5} {
+		s, _ := c.NewServo(ch)
+		s.SetHome(6000)
+		servos = append(servos, s)
+	}
+	err := c.GoHomeServos(servos...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdSetMultipleTargets, 2, 1, 0x70, 0x2e, 0x70, 0x2e})
+	port.AssertFrame(t, []byte{cmdSetMultipleTargets, 1, 5, 0x70, 0x2e})
+	port.AssertNoFrames(t)
+
+	s, _ := c.NewServo(7)
+	if c.GoHomeServos(s) == nil {
+		t.Error("expected an error for no home position")
+	}
+	// servos on another controller are rejected
+	other, _ := newTestController(t)
+	s, _ = other.NewServo(1)
+	s.SetHome(6000)
+	if c.GoHomeServos(servos[0], s) == nil {
+		t.Error("expected an error for a servo on another controller")
+	}
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestOnLimit(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetClamp(true)
+	var requested, applied uint16
+	n := 0
+	s.OnLimit(func(r, a uint16) {
+		requested, applied = r, a
+		n++
+	})
+	s.SetTarget(6000)
+	if n != 0 {
+		t.Error("unexpected limit callback")
+	}
+	s.SetTarget(12000)
+	if n != 1 || requested != 12000 || applied != 2500*uSec {
+		t.Errorf("bad limit callback %d %d %d", n, requested, applied)
+	}
+	s.SetTarget(100)
+	if n != 2 || requested != 100 || applied != 500*uSec {
+		t.Errorf("bad limit callback %d %d %d", n, requested, applied)
+	}
+	if len(port.Frames()) != 3 {
+		t.Error("expected 3 target writes")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// zeroPort is a serial port that responds with zero bytes.
+type zeroPort struct{}
+
+func (zeroPort) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	return len(buf), nil
+}
+func (zeroPort) Write(buf []byte) (int, error) { return len(buf), nil }
+
+func TestMovingStateInterval(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true, MovingStateInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	port.QueueResponse(0x01)
+	moving, err := c.GetMovingState()
+	if err != nil || !moving {
+		t.Fatalf("expected moving, got %t %v", moving, err)
+	}
+	port.AssertFrame(t, []byte{cmdGetMovingState})
+	// within the interval the cached state is returned
+	moving, err = c.GetMovingState()
+	if err != nil || !moving {
+		t.Fatalf("expected the cached moving state, got %t %v", moving, err)
+	}
+	port.AssertNoFrames(t)
+	// after the interval the controller is queried again
+	c.movingTime = time.Now().Add(-2 * time.Hour)
+	port.QueueResponse(0x00)
+	moving, err = c.GetMovingState()
+	if err != nil || moving {
+		t.Fatalf("expected not moving, got %t %v", moving, err)
+	}
+	port.AssertFrame(t, []byte{cmdGetMovingState})
+	// concurrent polling (run with -race)
+	c, err = NewController(&Config{Port: zeroPort{}, Compact: true, MovingStateInterval: 100 * time.Microsecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 1000; j++ {
+				c.GetMovingState()
+			}
+		}()
+	}
+	<-done
+	<-done
+}
+
+func TestBusAnyMoving(t *testing.T) {
+	port := sctest.NewPort()
+	bus := NewBus(port)
+	for _, dev := range []uint8{12, 13} {
+		_, err := bus.NewController(&Config{DeviceNumber: dev})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := bus.NewController(&Config{DeviceNumber: 12}); err == nil {
+		t.Error("expected an error for a duplicate device number")
+	}
+	port.Reset()
+	port.QueueResponse(0x00, 0x01)
+	moving, err := bus.AnyMoving()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moving {
+		t.Error("expected moving")
+	}
+	port.AssertFrame(t, []byte{0xaa, 12, cmdGetMovingState & 0x7f})
+	port.AssertFrame(t, []byte{0xaa, 13, cmdGetMovingState & 0x7f})
+}
+
+func TestBusBroadcast(t *testing.T) {
+	port := sctest.NewPort()
+	bus := NewBus(port)
+	for _, dev := range []uint8{12, 13} {
+		_, err := bus.NewController(&Config{DeviceNumber: dev, InitAction: InitNone})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	port.Reset()
+	err := bus.GoHome()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 12, cmdGoHome & 0x7f})
+	port.AssertFrame(t, []byte{0xaa, 13, cmdGoHome & 0x7f})
+	err = bus.StopScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 12, cmdStopScript & 0x7f})
+	port.AssertFrame(t, []byte{0xaa, 13, cmdStopScript & 0x7f})
+	// an error doesn't stop the broadcast
+	err = bus.Broadcast(func(c *Controller) error {
+		if c.device == 12 {
+			return errors.New("failed")
+		}
+		return c.GoHome()
+	})
+	if err == nil {
+		t.Error("expected an error")
+	}
+	port.AssertFrame(t, []byte{0xaa, 13, cmdGoHome & 0x7f})
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestState(t *testing.T) {
+	c0, _ := newTestController(t)
+	s, _ := c0.NewServo(3)
+	s.SetLimits(3000, 9000)
+	s.SetClamp(true)
+	s.SetDeadband(5)
+	s.SetHome(6000)
+	s.SetTarget(7000)
+	s.SetSpeed(20)
+	c0.NewServo(10)
+
+	data, err := c0.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, port := newTestController(t)
+	err = c1.UnmarshalState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := c1.servo[3]
+	if s1 == nil || c1.servo[10] == nil {
+		t.Fatal("servos not restored")
+	}
+	if s1.min != 3000 || s1.max != 9000 || !s1.clamp || s1.deadband != 5 || s1.home != 6000 {
+		t.Errorf("servo state not restored: %+v", s1.state())
+	}
+	if s1.sent {
+		t.Error("target should not be restored")
+	}
+	// the speed limit is sent, the unset acceleration limit is not
+	if s1.Speed() != 20 || s1.accelSet {
+		t.Errorf("speed %d, acceleration set %v", s1.Speed(), s1.accelSet)
+	}
+	port.AssertFrame(t, []byte{0x87, 3, 20, 0})
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestRateLimited(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if s.SetTargetRateLimited(6000) == nil {
+		t.Error("expected an error with no rate limiter")
+	}
+	// a slow rate so the updates are coalesced
+	err := s.StartRateLimiter(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := uint16(5000); v <= 6000; v += 100 {
+		err := s.SetTargetRateLimited(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.StopRateLimiter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the latest target is sent
+	port.AssertFrame(t, []byte{cmdSetTarget, 0x00, 0x70, 0x2e})
+	port.AssertNoFrames(t)
+}
+
+//-----------------------------------------------------------------------------
+
+func TestDecodeBool(t *testing.T) {
+	c, port := newTestController(t)
+	tests := []struct {
+		rsp     byte
+		moving  bool
+		running bool
+	}{
+		{0x00, false, true},
+		{0x01, true, false},
+		{0x80, false, true},
+		{0x81, true, false},
+	}
+	for _, v := range tests {
+		port.QueueResponse(v.rsp, v.rsp)
+		moving, err := c.GetMovingState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		running, err := c.GetScriptStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moving != v.moving || running != v.running {
+			t.Errorf("0x%02x: expected moving %v running %v, got %v %v", v.rsp, v.moving, v.running, moving, running)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestServoGroup(t *testing.T) {
+	c, port := newTestController(t)
+	servos := []*Servo{}
+	// group order differs from channel order, channels are not contiguous
+	for _, ch := range []uint8{4, 1, 2} {
+		s, _ := c.NewServo(ch)
+		servos = append(servos, s)
+	}
+	g, err := c.NewServoGroup(servos...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.SetTargets([]uint16{7000, 5000, 6000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdSetMultipleTargets, 2, 1, 0x08, 0x27, 0x70, 0x2e})
+	port.AssertFrame(t, []byte{cmdSetMultipleTargets, 1, 4, 0x58, 0x36})
+	port.AssertNoFrames(t)
+
+	if g.SetTargets([]uint16{6000}) == nil {
+		t.Error("expected an error for a target count mismatch")
+	}
+
+	err = g.SetSpeed(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range []byte{4, 1, 2} {
+		port.AssertFrame(t, []byte{cmdSetSpeed, ch, 10, 0})
+	}
+
+	c1, _ := newTestController(t)
+	s, _ := c1.NewServo(0)
+	if _, err := c.NewServoGroup(s); err == nil {
+		t.Error("expected an error for a servo on another controller")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestCalibration(t *testing.T) {
+	c, _ := newTestController(t)
+	s, _ := c.NewServo(0)
+	if _, err := s.DegreesToTarget(0); err == nil {
+		t.Error("expected an error with no calibration")
+	}
+	// reversed and offset: -45..45 degrees maps to 8000..4000
+	err := s.SetCalibration(&Calibration{
+		MinTarget:   4000,
+		MaxTarget:   8000,
+		MinPhysical: -45,
+		MaxPhysical: 45,
+		Reversed:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		deg    float64
+		target uint16
+	}{
+		{-45, 8000},
+		{45, 4000},
+		{0, 6000},
+		{22.5, 5000},
+		{-22.5, 7000},
+	}
+	for _, v := range tests {
+		target, err := s.DegreesToTarget(v.deg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != v.target {
+			t.Errorf("%g degrees: expected %d, got %d", v.deg, v.target, target)
+		}
+		deg, _ := s.TargetToDegrees(v.target)
+		if deg != v.deg {
+			t.Errorf("%d: expected %g degrees, got %g", v.target, v.deg, deg)
+		}
+	}
+	// percent follows the calibration direction
+	if s.PercentToTarget(0) != 8000 || s.PercentToTarget(100) != 4000 || s.PercentToTarget(25) != 7000 {
+		t.Error("bad percent conversion")
+	}
+	s.SetTargetPercent(25)
+	pct, _ := s.GetPercent()
+	if pct != 25 {
+		t.Errorf("expected 25%%, got %g%%", pct)
+	}
+	if s.SetCalibration(&Calibration{MinTarget: 8000, MaxTarget: 4000, MaxPhysical: 1}) == nil {
+		t.Error("expected an error for a bad calibration")
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestFlushInput(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	// stale bytes from an abandoned query
+	port.QueueResponse(0x12, 0x34, 0x56)
+	err := c.FlushInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port.Pending() != 0 {
+		t.Error("expected stale bytes to be discarded")
+	}
+	port.QueueResponse(0x70, 0x17)
+	pos, _ := s.GetPosition()
+	if pos != 6000 {
+		t.Errorf("expected position 6000, got %d", pos)
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func TestPWM(t *testing.T) {
+	c, port := newTestController(t)
+	s12, _ := c.NewServo(12)
+	s11, _ := c.NewServo(11)
+	err := c.EnablePWM(480, 960)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	if s12.SetTarget(6000) == nil {
+		t.Error("expected an error for a target on the PWM channel")
+	}
+	if c.SetTargets(11, []uint16{6000, 6000}) == nil {
+		t.Error("expected an error for a target on the PWM channel")
+	}
+	port.AssertNoFrames(t)
+	if s11.SetTarget(6000) != nil {
+		t.Error("unexpected error for a target on a non-PWM channel")
+	}
+	c.DisablePWM()
+	if s12.SetTarget(6000) != nil {
+		t.Error("unexpected error for a target with PWM disabled")
+	}
+
+	c6, _ := NewController(&Config{Port: port, Compact: true, Channels: 6})
+	if c6.EnablePWM(480, 960) == nil {
+		t.Error("expected an error for PWM on a Micro Maestro")
+	}
+}
+
+func TestStreamFrames(t *testing.T) {
+	c, port := newTestController(t)
+	for _, ch := range []uint8{0, 1, 3} {
+		c.NewServo(ch)
+	}
+	frames := make(chan Frame)
+	done := c.StreamFrames(frames)
+	frames <- Frame{0: 6000, 1: 6000, 3: 4000}
+	close(frames)
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x70, 0x2e, 0x70, 0x2e})
+	port.AssertFrame(t, []byte{0x9f, 1, 3, 0x20, 0x1f})
+	port.AssertNoFrames(t)
+
+	// a bad channel rejects the whole frame, and later frames are discarded
+	frames = make(chan Frame)
+	done = c.StreamFrames(frames)
+	frames <- Frame{0: 6000, 30: 6000}
+	if <-done == nil {
+		t.Error("expected an error for a bad channel")
+	}
+	frames <- Frame{0: 6000}
+	close(frames)
+	port.AssertNoFrames(t)
+	// a bad target rejects the whole frame
+	c.servo[3].SetLimits(5000, 7000)
+	if err := c.SetAllTargets(8000); err == nil {
+		t.Error("expected an error for a bad target")
+	}
+	if err := c.writeFrame(Frame{0: 6000, 5: 6000}); err == nil {
+		t.Error("expected an error for an unconfigured channel")
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestEchoVerify(t *testing.T) {
+	port := sctest.NewPort()
+	port.QueueResponse(0xaa)
+	c, err := NewController(&Config{Port: port, Compact: true, EchoVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	port.QueueResponse(0x84, 0, 0x70, 0x2e)
+	err = s.SetTarget(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the echo is discarded before the response is read
+	port.QueueResponse(0x90, 0)
+	port.QueueResponse(0x70, 0x17)
+	pos, err := s.GetPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 6000 {
+		t.Errorf("position %d, expected 6000", pos)
+	}
+	// bad or missing echo
+	port.QueueResponse(0x84, 0, 0x70, 0x2f)
+	if s.SetTarget(6000) == nil {
+		t.Error("expected an echo mismatch error")
+	}
+	if s.SetTarget(6000) == nil {
+		t.Error("expected an echo read error")
+	}
+}
+
+func TestTrim(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	s.SetTrim(-100)
+	s.SetTarget(6000)
+	port.AssertFrame(t, []byte{0x84, 0, 0x0c, 0x2e}) // 5900
+	if s.target != 6000 {
+		t.Errorf("logical target %d, expected 6000", s.target)
+	}
+	// trimmed targets are clamped to the limits
+	c.SetTargets(0, []uint16{4000})
+	port.AssertFrame(t, []byte{0x9f, 1, 0, 0x20, 0x1f}) // 4000
+	// off is not trimmed
+	s.Disable()
+	port.AssertFrame(t, []byte{0x84, 0, 0, 0})
+	port.QueueResponse(0x0c, 0x17) // 5900
+	pos, err := s.GetLogicalPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 6000 {
+		t.Errorf("logical position %d, expected 6000", pos)
+	}
+}
+
+func TestTrimConfirm(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	s.SetTrim(-100)
+	port.QueueResponse(0x0c, 0x17) // 5900
+	err := s.SetTargetConfirm(6000, 5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrimCentered(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	s.SetTrim(-100)
+	s.Center()
+	port.QueueResponse(0x0c, 0x17) // 5900
+	centered, err := s.IsCentered(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !centered {
+		t.Error("expected a trimmed servo to be centered")
+	}
+}
+
+func TestNamedSubroutine(t *testing.T) {
+	c, port := newTestController(t)
+	if c.RestartScript(128) == nil {
+		t.Error("expected an error for a bad subroutine")
+	}
+	if c.NameSubroutine("wave", 200) == nil {
+		t.Error("expected an error for a bad subroutine")
+	}
+	if c.RestartNamedSubroutine("wave") == nil {
+		t.Error("expected an error for an unknown subroutine")
+	}
+	port.AssertNoFrames(t)
+	c.NameSubroutine("wave", 5)
+	err := c.RestartNamedSubroutine("wave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xa7, 5})
+}
+
+func TestSetTargetAsync(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	err := <-s.SetTargetAsync(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x84, 0, 0x70, 0x2e})
+	if <-s.SetTargetAsync(9000) == nil {
+		t.Error("expected an error for an out of range target")
+	}
+}
+
+func TestGetPositionTimed(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	port.QueueResponse(0x70, 0x17)
+	before := time.Now()
+	pos, when, err := s.GetPositionTimed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 6000 {
+		t.Errorf("position %d, expected 6000", pos)
+	}
+	if when.Before(before) || when.After(time.Now()) {
+		t.Errorf("bad read time %v", when)
+	}
+	if _, _, err := s.GetPositionTimed(); err == nil {
+		t.Error("expected an error with no response")
+	}
+}
+
+func TestMaxCommandsPerSecond(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true, MaxCommandsPerSecond: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	// the auto baud byte and 7 commands use the burst, 20 more take >= 100ms
+	start := time.Now()
+	for i := 0; i < 27; i++ {
+		s.SetTarget(uint16(4000 + i))
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("27 commands in %v", d)
+	}
+	_, err = NewController(&Config{Port: port, MaxCommandsPerSecond: -1})
+	if err == nil {
+		t.Error("expected an error for a negative rate")
+	}
+}
+
+func TestOscillate(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	if s.Oscillate(context.Background(), 4000, 9000, time.Second) == nil {
+		t.Error("expected an error for an out of range target")
+	}
+	s.SetTarget(4000)
+	port.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := s.Oscillate(ctx, 4000, 8000, 40*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first sweep ends at the high target
+	port.AssertFrame(t, []byte{0x84, 0, 0x40, 0x3e}) // 8000
+	port.AssertFrame(t, []byte{0x84, 0, 0x20, 0x1f}) // 4000
+}
+
+func TestSweep(t *testing.T) {
+	c, port := newTestController(t)
+	s0, _ := c.NewServo(0)
+	s1, _ := c.NewServo(1)
+	err := c.Sweep([]*Servo{s0, s1}, 6000, 6100, 2*updateInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []uint16{6000, 6050, 6100} {
+		port.AssertFrame(t, []byte{cmdSetMultipleTargets, 2, 0, lo(x), hi(x), lo(x), hi(x)})
+	}
+	port.AssertNoFrames(t)
+	// servos on another controller are rejected
+	other, _ := newTestController(t)
+	s2, _ := other.NewServo(2)
+	if c.Sweep([]*Servo{s0, s2}, 6000, 6100, 0) == nil {
+		t.Error("expected an error for a servo on another controller")
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestInterpolatedPose(t *testing.T) {
+	c, port := newTestController(t)
+	s0, _ := c.NewServo(0)
+	s1, _ := c.NewServo(1)
+	c.SetTargets(0, []uint16{4000, 8000})
+	port.Reset()
+	targets := map[*Servo]uint16{s0: 8000, s1: 4000}
+	err := c.InterpolatedPose(targets, 40*time.Millisecond, 100, EaseInOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x14, 0x23, 0x4c, 0x3a}) // 4500, 7500
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x70, 0x2e, 0x70, 0x2e}) // 6000, 6000
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x4c, 0x3a, 0x14, 0x23}) // 7500, 4500
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x40, 0x3e, 0x20, 0x1f}) // 8000, 4000
+	port.AssertNoFrames(t)
+	if c.InterpolatedPose(targets, time.Second, 0, nil) == nil {
+		t.Error("expected an error for a bad update rate")
+	}
+}
+
+func TestEasing(t *testing.T) {
+	for _, fn := range []Easing{Linear, EaseIn, EaseOut, EaseInOut} {
+		if fn(0) != 0 || fn(1) != 1 {
+			t.Error("easing functions should map 0 to 0 and 1 to 1")
+		}
+	}
+	if EaseInOut(0.5) != 0.5 || EaseInOut(0.25) >= 0.25 {
+		t.Error("bad ease in/out")
+	}
+	if EaseIn(0.5) >= 0.5 || EaseOut(0.5) <= 0.5 {
+		t.Error("bad ease in or ease out")
+	}
+
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetTarget(4000)
+	port.Reset()
+	err := s.MoveToEased(context.Background(), 8000, time.Millisecond, 2, EaseIn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x84, 0, 0x08, 0x27}) // 5000
+	port.AssertFrame(t, []byte{0x84, 0, 0x40, 0x3e}) // 8000
+}
+
+func TestSetAllTargets(t *testing.T) {
+	c, port := newTestController(t)
+	for _, ch := range []uint8{0, 1, 5} {
+		c.NewServo(ch)
+	}
+	err := c.SetAllTargets(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x70, 0x2e, 0x70, 0x2e})
+	port.AssertFrame(t, []byte{0x9f, 1, 5, 0x70, 0x2e})
+	c.servo[5].SetLimits(4000, 5000)
+	if c.SetAllTargets(6000) == nil {
+		t.Error("expected an error for an out of range target")
+	}
+}
+
+func TestSetAllTargetsJrkMode(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true, TargetMode: TargetModeJrk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	for _, ch := range []uint8{0, 1} {
+		c.NewServo(ch)
+	}
+	// frame functions send servo targets regardless of the SetTargets mode
+	err = c.SetAllTargets(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x9f, 2, 0, 0x70, 0x2e, 0x70, 0x2e})
+	err = c.GoHomeServos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertNoFrames(t)
+}
+
+// errorPort is a serial port with a read error.
+type errorPort struct {
+	err error
+}
+
+func (p errorPort) Read(buf []byte) (int, error)  { return 0, p.err }
+func (p errorPort) Write(buf []byte) (int, error) { return len(buf), nil }
+
+func TestTimeoutError(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	port.QueueResponse(0x70)
+	_, err := s.GetPosition()
+	var te *TimeoutError
+	if !errors.As(err, &te) || te.Got != 1 || te.Want != 2 {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	_, err = s.GetPosition()
+	if !errors.As(err, &te) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	// other errors are returned as is
+	errPort := errors.New("port closed")
+	c, _ = NewController(&Config{Port: errorPort{errPort}, InitAction: InitNone})
+	_, err = c.GetErrors()
+	if err != errPort {
+		t.Errorf("expected the port error, got %v", err)
+	}
+	// io.EOF is a closed connection (not a timeout) on a port with read deadlines
+	c, _ = NewController(&Config{Port: &deadlinePort{Port: sctest.NewPort()}, InitAction: InitNone})
+	_, err = c.GetErrors()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestFrameWriter(t *testing.T) {
+	c, port := newTestController(t)
+	for ch := uint8(2); ch < 4; ch++ {
+		c.NewServo(ch)
+	}
+	if _, err := c.NewFrameWriter(2, 3); err == nil {
+		t.Error("expected an error for an unconfigured channel")
+	}
+	w, err := c.NewFrameWriter(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []uint16{6000, 4000} {
+		err := w.Write([]uint16{v, v})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	port.AssertFrame(t, []byte{0x9f, 2, 2, 0x70, 0x2e, 0x70, 0x2e})
+	port.AssertFrame(t, []byte{0x9f, 2, 2, 0x20, 0x1f, 0x20, 0x1f})
+	if w.Write([]uint16{6000}) == nil {
+		t.Error("expected an error for the wrong number of targets")
+	}
+}
+
+func TestSetTargetsRange(t *testing.T) {
+	c, port := newTestController(t)
+	c.NewServo(23)
+	if c.SetTargets(23, []uint16{6000, 6000, 6000}) == nil {
+		t.Error("expected an error for channels past the last channel")
+	}
+	c6, _ := NewController(&Config{Port: port, Compact: true, Channels: 6, InitAction: InitNone})
+	c6.NewServo(5)
+	if c6.SetTargets(5, []uint16{6000, 6000}) == nil {
+		t.Error("expected an error for channels past the last channel")
+	}
+	port.AssertNoFrames(t)
+}
+
+// crcPort is a serial port that responds to GetErrors commands in one crc mode.
+type crcPort struct {
+	crc bool   // does the controller expect a crc byte?
+	rsp []byte // pending response
+}
+
+func (p *crcPort) Write(buf []byte) (int, error) {
+	crc := len(buf) == 2 && buf[1] == crc7(0, buf[:1])&0x7f
+	if buf[0] == cmdGetErrors && crc == p.crc {
+		p.rsp = []byte{0, 0}
+	}
+	return len(buf), nil
+}
+
+func (p *crcPort) Read(buf []byte) (int, error) {
+	n := copy(buf, p.rsp)
+	p.rsp = p.rsp[n:]
+	return n, nil
+}
+
+func TestVerifyCRCMode(t *testing.T) {
+	for _, v := range []struct {
+		ctrl, cfg bool
+		ok        bool
+	}{
+		{false, false, true},
+		{true, true, true},
+		{true, false, false},
+		{false, true, false},
+	} {
+		c, _ := NewController(&Config{Port: &crcPort{crc: v.ctrl}, Compact: true, Crc: v.cfg, InitAction: InitNone})
+		err := c.VerifyCRCMode()
+		if (err == nil) != v.ok {
+			t.Errorf("controller crc %v, config crc %v: %v", v.ctrl, v.cfg, err)
+		}
+		if c.crc != v.cfg {
+			t.Error("crc setting not restored")
+		}
+	}
+}
+
+func TestNewServo(t *testing.T) {
+	c, _ := newTestController(t)
+	if c.GetServo(3) != nil || c.GetServo(maxServos) != nil {
+		t.Error("expected no servo")
+	}
+	s0, _ := c.NewServo(3)
+	s0.SetLimits(4000, 8000)
+	s1, _ := c.NewServo(3)
+	if s1 != s0 || c.GetServo(3) != s0 {
+		t.Error("expected the existing servo")
+	}
+	if s1.min != 4000 || s1.max != 8000 {
+		t.Error("servo limits lost")
+	}
+}
+
+// positionPort is a serial port that responds to GetPosition commands with
+// a position derived from the channel number.
+type positionPort struct {
+	rsp []byte // pending response
+}
+
+func positionOf(channel uint8) uint16 {
+	return 4000 + 100*uint16(channel)
+}
+
+func (p *positionPort) Write(buf []byte) (int, error) {
+	if len(buf) == 2 && buf[0] == cmdGetPosition {
+		pos := positionOf(buf[1])
+		p.rsp = append(p.rsp, byte(pos), byte(pos>>8))
+	}
+	return len(buf), nil
+}
+
+func (p *positionPort) Read(buf []byte) (int, error) {
+	n := copy(buf, p.rsp)
+	p.rsp = p.rsp[n:]
+	return n, nil
+}
+
+// queries from multiple goroutines, interleaved with target writes, get the right response
+func TestConcurrentQuery(t *testing.T) {
+	c, err := NewController(&Config{Port: &positionPort{}, Compact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 8
+	for ch := uint8(0); ch < 2*n; ch++ {
+		c.NewServo(ch)
+	}
+	errs := make(chan error, 2*n)
+	for ch := uint8(0); ch < n; ch++ {
+		go func(s *Servo) {
+			for i := 0; i < 100; i++ {
+				pos, err := s.GetPosition()
+				if err == nil && pos != positionOf(s.channel) {
+					err = fmt.Errorf("channel %d: position %d", s.channel, pos)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(c.servo[ch])
+		go func(s *Servo) {
+			for i := 0; i < 100; i++ {
+				err := s.SetTarget(uint16(4000 + i))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(c.servo[n+ch])
+	}
+	for i := 0; i < 2*n; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestPositionFraction(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	for _, v := range []struct {
+		pos uint16
+		f   float64
+	}{
+		{5000, 0.25},
+		{8000, 1},
+		{9000, 1},
+		{0, 0},
+	} {
+		port.QueueResponse(byte(v.pos), byte(v.pos>>8))
+		f, err := s.PositionFraction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != v.f {
+			t.Errorf("position %d: fraction %v, expected %v", v.pos, f, v.f)
+		}
+	}
+}
+
+func TestSetCompact(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, DeviceNumber: testDevice, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(1)
+	s.SetTarget(6000)
+	port.AssertFrame(t, []byte{0xaa, testDevice, 0x04, 1, 0x70, 0x2e})
+	c.SetCompact(true)
+	s.SetTarget(4000)
+	port.AssertFrame(t, []byte{0x84, 1, 0x20, 0x1f})
+	c.SetCompact(false)
+	s.SetTarget(6000)
+	port.AssertFrame(t, []byte{0xaa, testDevice, 0x04, 1, 0x70, 0x2e})
+
+	// the crc byte is computed over the frame for the current protocol
+	c, err = NewController(&Config{Port: port, DeviceNumber: testDevice, Crc: true, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ = c.NewServo(1)
+	s.SetTarget(6000)
+	port.AssertFrame(t, crcFrame([]byte{0xaa, testDevice, 0x04, 1, 0x70, 0x2e}))
+	c.SetCompact(true)
+	s.SetTarget(4000)
+	port.AssertFrame(t, crcFrame([]byte{0x84, 1, 0x20, 0x1f}))
+	c.SetCompact(false)
+	s.SetTarget(6000)
+	port.AssertFrame(t, crcFrame([]byte{0xaa, testDevice, 0x04, 1, 0x70, 0x2e}))
+
+	bus := NewBus(port)
+	c, _ = bus.NewController(&Config{DeviceNumber: 1, InitAction: InitNone})
+	if c.SetCompact(true) == nil {
+		t.Error("expected an error for the compact protocol on a shared bus")
+	}
+}
+
+func TestEStop(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	calls := 0
+	c.SetEStop(func() error {
+		calls++
+		return s.Disable()
+	})
+	c.TriggerEStop()
+	c.TriggerEStop()
+	if calls != 1 {
+		t.Errorf("estop function called %d times", calls)
+	}
+	port.AssertFrame(t, []byte{0x84, 0, 0, 0})
+	for _, err := range []error{
+		s.SetTarget(6000),
+		c.SetTargets(0, []uint16{6000}),
+		s.SetSpeed(10),
+		c.GoHome(),
+	} {
+		if !errors.Is(err, ErrEStopped) {
+			t.Errorf("expected ErrEStopped, got %v", err)
+		}
+	}
+	port.AssertNoFrames(t)
+	c.ClearEStop()
+	err := s.SetTarget(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0x84, 0, 0x70, 0x2e})
+}
+
+func TestSetSpeedDegPerSec(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if s.SetSpeedDegPerSec(90) == nil {
+		t.Error("expected an error with no calibration")
+	}
+	s.SetCalibration(&Calibration{MinTarget: 4000, MaxTarget: 8000, MinPhysical: 0, MaxPhysical: 180})
+	// 1000us over 180 degrees, 90 degrees/s is 500us/s
+	for _, v := range []struct {
+		dps   float64
+		speed Speed
+	}{
+		{90, 20},
+		{0.01, 1},
+		{0, 0},
+	} {
+		err := s.SetSpeedDegPerSec(v.dps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Speed() != v.speed {
+			t.Errorf("%v degrees/s: speed %d, expected %d", v.dps, s.Speed(), v.speed)
+		}
+	}
+	port.AssertFrame(t, []byte{0x87, 0, 20, 0})
+}
+
+func TestWatchdog(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if c.StartWatchdog(0) == nil {
+		t.Error("expected an error for a zero timeout")
+	}
+	err := c.StartWatchdog(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// targets set within the timeout, then no targets set
+	for i := 0; i < 4; i++ {
+		s.SetTarget(uint16(6000 + i))
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(60 * time.Millisecond)
+	c.StopWatchdog()
+	frames := port.Frames()
+	if len(frames) < 5 {
+		t.Fatalf("%d frames, expected >= 5", len(frames))
+	}
+	for _, f := range frames[:4] {
+		if f[2] == 0 && f[3] == 0 {
+			t.Error("servo turned off by the watchdog")
+		}
+	}
+	if f := frames[4]; f[2] != 0 || f[3] != 0 {
+		t.Error("servo not turned off by the watchdog")
+	}
+	if s.Enabled() {
+		t.Error("servo turned off by the watchdog is enabled")
+	}
+	// the deadband doesn't suppress the target after a watchdog turn off
+	s.SetDeadband(10)
+	port.Reset()
+	s.SetTarget(6003)
+	if n := len(port.Frames()); n != 1 {
+		t.Errorf("%d frames, expected 1", n)
+	}
+	if !s.Enabled() {
+		t.Error("expected the servo to be enabled")
+	}
+}
+
+func TestWatchdogClose(t *testing.T) {
+	c, port := newTestController(t)
+	c.NewServo(0)
+	err := c.StartWatchdog(10 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	port.AssertNoFrames(t)
+}
+
+func TestWatchdogQuery(t *testing.T) {
+	c, port := newTestController(t)
+	c.NewServo(0)
+	err := c.StartWatchdog(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// any command (including queries) kicks the watchdog
+	for i := 0; i < 4; i++ {
+		port.QueueResponse(0, 0)
+		c.GetErrors()
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.StopWatchdog()
+	for _, f := range port.Frames() {
+		if f[0] != cmdGetErrors {
+			t.Errorf("unexpected frame %v", f)
+		}
+	}
+}
+
+func TestIsHome(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if _, err := s.IsHome(4); err == nil {
+		t.Error("expected an error with no home position")
+	}
+	s.SetHome(6000)
+	port.QueueResponse(0x72, 0x17, 0x80, 0x17) // 6002, 6016
+	for _, expect := range []bool{true, false} {
+		home, err := s.IsHome(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if home != expect {
+			t.Errorf("home %v, expected %v", home, expect)
+		}
+	}
+}
+
+// frameTransport is a transport that records command frames and returns a fixed response.
+type frameTransport struct {
+	frames [][]byte
+	rsp    []byte
+}
+
+func (t *frameTransport) WriteFrame(frame []byte) error {
+	t.frames = append(t.frames, append([]byte(nil), frame...))
+	return nil
+}
+
+func (t *frameTransport) ReadN(n int) ([]byte, error) {
+	return t.rsp[:n], nil
+}
+
+func TestTransport(t *testing.T) {
+	tr := &frameTransport{rsp: []byte{0x70, 0x17}}
+	c, err := NewController(&Config{Transport: tr, Compact: true, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	s.SetTarget(6000)
+	pos, err := s.GetPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 6000 {
+		t.Errorf("position %d, expected 6000", pos)
+	}
+	if len(tr.frames) != 2 || tr.frames[1][0] != cmdGetPosition {
+		t.Errorf("bad frames %v", tr.frames)
+	}
+	// an io.ReadWriter is adapted
+	if _, ok := NewTransport(sctest.NewPort()).(Deadliner); ok {
+		t.Error("port without deadlines adapted as a Deadliner")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c, _ := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetTarget(5000)
+	if s.History() != nil {
+		t.Error("expected no history")
+	}
+	s.EnableHistory(3)
+	for _, v := range []uint16{6000, 6001, 6002, 6003} {
+		s.SetTarget(v)
+	}
+	h := s.History()
+	if len(h) != 3 || h[0].Target != 6001 || h[2].Target != 6003 {
+		t.Errorf("bad history %v", h)
+	}
+	if h[0].Time.After(h[2].Time) {
+		t.Error("history is not oldest first")
+	}
+}
+
+func TestSetDeviceTargets(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, DeviceNumber: 12, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.SetDeviceTargets([]DeviceTarget{
+		{Device: 12, Channel: 1, Target: 6000},
+		{Device: 11, Jrk: true, Target: 3229},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 12, 0x04, 1, 0x70, 0x2e, 0xaa, 11, 0x5d, 0x64})
+	for _, bad := range []DeviceTarget{
+		{Device: 128},
+		{Device: 11, Jrk: true, Target: 4096},
+		{Device: 12, Channel: 24},
+		{Device: 12, Target: 0x4000},
+	} {
+		if c.SetDeviceTargets([]DeviceTarget{{Device: 12, Target: 6000}, bad}) == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestSetDeviceTargetsChecks(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, DeviceNumber: 12, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(1)
+	s.SetLimits(4000, 8000)
+	// servo limits
+	if err := c.SetDeviceTargets([]DeviceTarget{{Device: 12, Channel: 1, Target: 9000}}); err == nil {
+		t.Error("expected a limit error")
+	}
+	// e-stop
+	c.TriggerEStop()
+	if err := c.SetDeviceTargets([]DeviceTarget{{Device: 12, Channel: 1, Target: 6000}}); !errors.Is(err, ErrEStopped) {
+		t.Errorf("expected ErrEStopped, got %v", err)
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestBusSetDeviceTargets(t *testing.T) {
+	port := sctest.NewPort()
+	bus := NewBus(port)
+	bus.NewController(&Config{DeviceNumber: 12, InitAction: InitNone})
+	c13, _ := bus.NewController(&Config{DeviceNumber: 13, InitAction: InitNone})
+	s, _ := c13.NewServo(0)
+	s.SetTrim(10)
+	err := bus.SetDeviceTargets([]DeviceTarget{
+		{Device: 12, Channel: 1, Target: 6000},
+		{Device: 13, Channel: 0, Target: 6000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{0xaa, 12, 0x04, 1, 0x70, 0x2e, 0xaa, 13, 0x04, 0, 0x7a, 0x2e})
+	if s.target != 6000 {
+		t.Errorf("target %d, expected 6000", s.target)
+	}
+	// unknown device
+	if err := bus.SetDeviceTargets([]DeviceTarget{{Device: 14, Target: 6000}}); err == nil {
+		t.Error("expected an error for a device not on the bus")
+	}
+	// e-stop of any addressed device
+	c13.TriggerEStop()
+	err = bus.SetDeviceTargets([]DeviceTarget{
+		{Device: 12, Channel: 1, Target: 6000},
+		{Device: 13, Channel: 0, Target: 6000},
+	})
+	if !errors.Is(err, ErrEStopped) {
+		t.Errorf("expected ErrEStopped, got %v", err)
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestSetTargetClamped(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	applied, err := s.SetTargetClamped(9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 8000 {
+		t.Errorf("applied %d, expected 8000", applied)
+	}
+	port.AssertFrame(t, []byte{0x84, 0, 0x40, 0x3e})
+	// the deadband suppresses the write
+	s.SetDeadband(10)
+	applied, _ = s.SetTargetClamped(7995)
+	if applied != 8000 {
+		t.Errorf("applied %d, expected 8000", applied)
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestMaxFrameLength(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true, Crc: true, MaxFrameLength: 16, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := make([]uint16, 7)
+	for ch := range targets {
+		c.NewServo(uint8(ch))
+		targets[ch] = 6000
+	}
+	// 1 + 2 + 2*6 + 1 = 16 bytes
+	err = c.SetTargets(0, targets[:6])
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	if c.SetTargets(0, targets) == nil {
+		t.Error("expected an error for an 18 byte frame")
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestEstimateMoveTime(t *testing.T) {
+	c, _ := newTestController(t)
+	s, _ := c.NewServo(0)
+	// no limits
+	if d := s.EstimateMoveTime(4000, 8000); d != 0 {
+		t.Errorf("move time %v, expected 0", d)
+	}
+	// 1000us at 25us/s per unit of speed
+	s.SetSpeed(40)
+	if d := s.EstimateMoveTime(4000, 8000); d != time.Second {
+		t.Errorf("move time %v, expected 1s", d)
+	}
+	// 1000us at 312.5us/s/s per unit of acceleration, limited to 1000us/s
+	s.SetAcceleration(8)
+	if d := s.EstimateMoveTime(8000, 4000); d != 1400*time.Millisecond {
+		t.Errorf("move time %v, expected 1.4s", d)
+	}
+	// never reaches the speed limit
+	s.SetSpeed(0)
+	if d := s.EstimateMoveTime(4000, 6500); d != time.Second {
+		t.Errorf("move time %v, expected 1s", d)
+	}
+}
+
+func TestSetTargetDegreesWrap(t *testing.T) {
+	c, _ := newTestController(t)
+	s, _ := c.NewServo(0)
+	if s.SetTargetDegreesWrap(90) == nil {
+		t.Error("expected an error with no calibration")
+	}
+	// two full turns, 10 ticks per degree
+	s.SetLimits(1000, 8200)
+	s.SetCalibration(&Calibration{MinTarget: 1000, MaxTarget: 8200, MinPhysical: 0, MaxPhysical: 720})
+	for _, v := range []struct {
+		deg    float64
+		target uint16
+	}{
+		{90, 1900},   // from 0
+		{-10, 4500},  // 350 is closer than 710
+		{400, 5000},  // 400 is closer than 40
+		{-370, 4500}, // 350 is closer than 710
+	} {
+		err := s.SetTargetDegreesWrap(v.deg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.target != v.target {
+			t.Errorf("%v degrees: target %d, expected %d", v.deg, s.target, v.target)
+		}
+	}
+	// less than a full turn, out of range angles are clamped
+	s.SetCalibration(&Calibration{MinTarget: 1000, MaxTarget: 3700, MinPhysical: 0, MaxPhysical: 270})
+	for _, v := range []struct {
+		deg    float64
+		target uint16
+	}{
+		{280, 3700},
+		{350, 1000},
+		{-90, 3700},
+	} {
+		err := s.SetTargetDegreesWrap(v.deg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.target != v.target {
+			t.Errorf("%v degrees: target %d, expected %d", v.deg, s.target, v.target)
+		}
+	}
+}
+
+// deadlinePort is a test port with read deadlines.
+type deadlinePort struct {
+	*sctest.Port
+	deadlines []time.Time
+}
+
+func (p *deadlinePort) SetReadDeadline(t time.Time) error {
+	p.deadlines = append(p.deadlines, t)
+	return nil
+}
+
+func TestGetPositionContext(t *testing.T) {
+	port := &deadlinePort{Port: sctest.NewPort()}
+	c, err := NewController(&Config{Port: port, Compact: true, ReadTimeout: time.Second, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	deadline := time.Now().Add(50 * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	port.QueueResponse(0x70, 0x17)
+	pos, err := s.GetPositionContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 6000 {
+		t.Errorf("position %d, expected 6000", pos)
+	}
+	// the context deadline is used for the query (and then cleared), then the read timeout
+	port.QueueResponse(0x70, 0x17)
+	s.GetPosition()
+	if len(port.deadlines) != 3 || !port.deadlines[0].Equal(deadline) || !port.deadlines[1].IsZero() || port.deadlines[2].Before(deadline) {
+		t.Errorf("bad read deadlines %v", port.deadlines)
+	}
+	cancel()
+	if _, err := s.GetPositionContext(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestGetPositionContextNoTimeout(t *testing.T) {
+	port := &deadlinePort{Port: sctest.NewPort()}
+	c, err := NewController(&Config{Port: port, Compact: true, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	port.QueueResponse(0x70, 0x17)
+	_, err = s.GetPositionContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a read timeout a later query has no deadline
+	port.QueueResponse(0x70, 0x17)
+	_, err = s.GetPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(port.deadlines) != 2 || !port.deadlines[1].IsZero() {
+		t.Errorf("stale read deadline %v", port.deadlines)
+	}
+}
+
+func TestPositionCheck(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	s.SetPositionCheck(&PositionCheck{Margin: 100, Retries: 2})
+	// implausible, implausible, plausible
+	port.QueueResponse(0xff, 0xff)
+	port.QueueResponse(0x10, 0x0f) // 3856
+	port.QueueResponse(0x1c, 0x1f) // 7964
+	pos, err := s.GetPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 7964 {
+		t.Errorf("position %d, expected 7964", pos)
+	}
+	if n := len(port.Frames()); n != 3 {
+		t.Errorf("%d queries, expected 3", n)
+	}
+	// never plausible
+	port.Reset()
+	for i := 0; i < 3; i++ {
+		port.QueueResponse(0x00, 0x30)
+	}
+	if _, err := s.GetPosition(); err == nil {
+		t.Error("expected an implausible position error")
+	}
+	// 0 is plausible
+	port.QueueResponse(0x00, 0x00)
+	if pos, err := s.GetPosition(); err != nil || pos != 0 {
+		t.Errorf("position %d, error %v", pos, err)
+	}
+	// the other position reads
+	port.Reset()
+	port.QueueResponse(0xff, 0xff, 0x70, 0x17)
+	if pos, _, err := s.GetPositionTimed(); err != nil || pos != 6000 {
+		t.Errorf("timed position %d, error %v", pos, err)
+	}
+	port.QueueResponse(0xff, 0xff, 0x70, 0x17)
+	if pos, err := s.GetPositionContext(context.Background()); err != nil || pos != 6000 {
+		t.Errorf("context position %d, error %v", pos, err)
+	}
+	port.QueueResponse(0xff, 0xff, 0x70, 0x17)
+	if pos, err := c.GetPositionsRange(0, 1); err != nil || pos[0] != 6000 {
+		t.Errorf("range positions %v, error %v", pos, err)
+	}
+	if n := len(port.Frames()); n != 6 {
+		t.Errorf("%d queries, expected 6", n)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(5000, 7000)
+	// the position is read after each step
+	for _, pos := range []uint16{5500, 5000, 6500, 7000} {
+		port.QueueResponse(byte(pos), byte(pos>>8))
+	}
+	// stall at 7000
+	err := s.Calibrate(context.Background(), 500, 0, func(pos uint16) bool { return pos >= 7000 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.min != 5000 || s.max != 6500 {
+		t.Errorf("limits %d..%d, expected 5000..6500", s.min, s.max)
+	}
+	if n := len(port.Frames()); n != 11 {
+		t.Errorf("%d frames, expected 11", n)
+	}
+	if s.target != 6000 {
+		t.Errorf("target %d, expected 6000", s.target)
+	}
+	// canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.Calibrate(ctx, 500, time.Second, func(pos uint16) bool { return false })
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if s.min != 5000 || s.max != 6500 {
+		t.Errorf("limits changed to %d..%d", s.min, s.max)
+	}
+}
+
+// writeErrorPort is a serial port with a write error.
+type writeErrorPort struct{}
+
+func (p writeErrorPort) Read(buf []byte) (int, error)  { return 0, io.EOF }
+func (p writeErrorPort) Write(buf []byte) (int, error) { return 0, errors.New("port closed") }
+
+func TestConfigBuilder(t *testing.T) {
+	if _, err := NewConfig(nil).Build(); !errors.Is(err, ErrNoPort) {
+		t.Errorf("expected ErrNoPort, got %v", err)
+	}
+	if _, err := NewConfig(sctest.NewPort()).Compact().WithDevice(12).Build(); err == nil {
+		t.Error("expected an error for a compact protocol device number")
+	}
+	tr := &frameTransport{}
+	cfg, err := NewConfig(nil).WithTransport(tr).Compact().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Transport != tr || !cfg.Compact {
+		t.Error("bad configuration")
+	}
+}
+
+func TestConstructionErrors(t *testing.T) {
+	_, err := NewController(&Config{})
+	if !errors.Is(err, ErrNoPort) {
+		t.Errorf("expected ErrNoPort, got %v", err)
+	}
+	_, err = NewController(&Config{Port: writeErrorPort{}})
+	if !errors.Is(err, ErrAutoBaud) {
+		t.Errorf("expected ErrAutoBaud, got %v", err)
+	}
+	if errors.Is(err, ErrNoPort) {
+		t.Error("unexpected ErrNoPort")
+	}
+}
+
+func TestSequence(t *testing.T) {
+	c, _ := newTestController(t)
+	c.NewServo(0)
+	sent := make(chan uint16, 8)
+	c.OnTargetSet(func(channel uint8, target uint16) { sent <- target })
+	frames := []Frame{{0: 4000}, {0: 5000}, {0: 6000}}
+	q, err := c.NewSequence(frames, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Seek(3); err == nil {
+		t.Error("expected a bad frame error")
+	}
+	// start paused
+	q.Pause()
+	done := make(chan error, 1)
+	go func() { done <- q.Play(context.Background()) }()
+	// seeking while paused sends the frame
+	q.Seek(1)
+	if x := <-sent; x != 5000 {
+		t.Errorf("target %d, expected 5000", x)
+	}
+	select {
+	case x := <-sent:
+		t.Errorf("unexpected target %d while paused", x)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if q.Frame() != 2 {
+		t.Errorf("frame %d, expected 2", q.Frame())
+	}
+	// resume with the next frame
+	q.Resume()
+	if x := <-sent; x != 6000 {
+		t.Errorf("target %d, expected 6000", x)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// canceled while paused
+	q.Seek(0)
+	q.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.Play(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAutoCheckErrors(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, DeviceNumber: testDevice, AutoCheckErrors: true, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := c.NewServo(0)
+	// serial buffer full
+	port.QueueResponse(byte(ErrSerialBufferFull), 0)
+	err = s.SetTarget(6000)
+	if !errors.Is(err, ErrBufferFull) {
+		t.Errorf("expected ErrBufferFull, got %v", err)
+	}
+	port.AssertFrame(t, []byte{0xaa, testDevice, cmdSetTarget & 0x7f, 0, 0x70, 0x2e})
+	port.AssertFrame(t, []byte{0xaa, testDevice, cmdGetErrors & 0x7f})
+	// the next write is delayed
+	port.QueueResponse(0, 0)
+	start := time.Now()
+	err = s.SetTarget(7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < bufferFullDelay/2 {
+		t.Errorf("write after buffer full not delayed (%v)", d)
+	}
+	// other errors
+	port.QueueResponse(byte(ErrSerialCrc), 0)
+	err = s.SetTarget(8000)
+	var cerr *ControllerError
+	if !errors.As(err, &cerr) || !cerr.Has(ErrSerialCrc) || errors.Is(err, ErrBufferFull) {
+		t.Errorf("expected a crc error, got %v", err)
+	}
+	// the command was sent, so the servo state is recorded
+	if s.target != 8000 || !s.sent {
+		t.Errorf("expected target 8000 to be recorded, got %d", s.target)
+	}
+	var sent []uint16
+	c.OnTargetSet(func(channel uint8, target uint16) {
+		sent = append(sent, target)
+	})
+	port.QueueResponse(byte(ErrSerialSignal), 0)
+	if c.SetTargets(0, []uint16{7000}) == nil {
+		t.Error("expected a controller error")
+	}
+	if len(sent) != 1 || sent[0] != 7000 || s.target != 7000 {
+		t.Errorf("expected the target callback for 7000, got %v", sent)
+	}
+	port.QueueResponse(byte(ErrSerialSignal), 0)
+	if s.SetSpeed(20) == nil {
+		t.Error("expected a controller error")
+	}
+	if s.Speed() != 20 {
+		t.Errorf("expected speed 20 to be recorded, got %d", s.Speed())
+	}
+}
+
+func TestJog(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(5000, 5100)
+	if err := s.StartJog(1, 40); err == nil {
+		t.Error("expected an error for no target")
+	}
+	s.SetTarget(5000)
+	port.Reset()
+	// jog up to the limit
+	err := s.StartJog(1, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StartJog(1, 40); err == nil {
+		t.Error("expected an error for a running jog")
+	}
+	<-s.jog.done
+	if s.Jogging() {
+		t.Error("expected the jog to stop at the limit")
+	}
+	err = s.StopJog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []uint16{5040, 5080, 5100} {
+		port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(x), hi(x)})
+	}
+	port.AssertNoFrames(t)
+	// restart after a limit stop (without StopJog)
+	err = s.StartJog(-1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.jog.done
+	port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(5000), hi(5000)})
+	err = s.StartJog(-1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.jog.done
+	port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(5000), hi(5000)})
+	if err := s.StopJog(); err != nil {
+		t.Fatal(err)
+	}
+	// stop a running jog
+	s.SetLimits(1000, 9000)
+	err = s.StartJog(-1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Jogging() {
+		t.Error("expected a running jog")
+	}
+	err = s.StopJog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Jogging() {
+		t.Error("expected the jog to be stopped")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c, port := newTestController(t)
+	s0, _ := c.NewServo(0)
+	c.NewServo(2)
+	err := s0.SetTarget(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.Reset()
+	port.QueueResponse(0x70, 0x17, 0x00, 0x00, 0x01, byte(ErrSerialCrc), 0x00)
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdGetPosition, 0})
+	port.AssertFrame(t, []byte{cmdGetPosition, 2})
+	port.AssertFrame(t, []byte{cmdGetMovingState})
+	port.AssertFrame(t, []byte{cmdGetErrors})
+	port.AssertNoFrames(t)
+	if snap.Position[0] != 6000 || snap.Position[2] != 0 || len(snap.Position) != 2 {
+		t.Errorf("bad positions %v", snap.Position)
+	}
+	if len(snap.Enabled) != 1 || snap.Enabled[0] != 0 {
+		t.Errorf("bad enabled channels %v", snap.Enabled)
+	}
+	if !snap.Moving || snap.Errors != uint16(ErrSerialCrc) {
+		t.Errorf("bad moving state %t or errors 0x%04x", snap.Moving, snap.Errors)
+	}
+	if c.Stats().CrcErrors != 1 {
+		t.Error("snapshot errors not counted")
+	}
+	// a read error fails the snapshot
+	if _, err := c.Snapshot(); err == nil {
+		t.Error("expected an error for no response")
+	}
+}
+
+func TestGetPositionsRange(t *testing.T) {
+	c, port := newTestController(t)
+	if _, err := c.GetPositionsRange(23, 2); err == nil {
+		t.Error("expected a bad channel range error")
+	}
+	port.QueueResponse(0x70, 0x17, 0x40, 0x1f)
+	pos, err := c.GetPositionsRange(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pos) != 2 || pos[0] != 6000 || pos[1] != 8000 {
+		t.Errorf("positions %v, expected [6000 8000]", pos)
+	}
+	port.AssertFrame(t, []byte{cmdGetPosition, 4})
+	port.AssertFrame(t, []byte{cmdGetPosition, 5})
+	// partial read
+	port.QueueResponse(0x70, 0x17)
+	pos, err = c.GetPositionsRange(0, 3)
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if len(pos) != 3 || pos[0] != 6000 || pos[1] != 0 {
+		t.Errorf("positions %v, expected [6000 0 0]", pos)
+	}
+}
+
+func TestMaxSlew(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	if err := s.SetMaxSlew(-1); err == nil {
+		t.Error("expected a negative slew rate error")
+	}
+	// 100 ticks/second is 2 ticks per update interval
+	err := s.SetMaxSlew(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first target is sent immediately
+	err = s.SetTarget(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(6000), hi(6000)})
+	// ramp
+	err = s.SetTarget(6005)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.slewer.done
+	for _, x := range []uint16{6002, 6004, 6005} {
+		port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(x), hi(x)})
+	}
+	port.AssertNoFrames(t)
+	// disable stops a ramp
+	err = s.SetTarget(8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Disable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := port.Frames()
+	if last := frames[len(frames)-1]; last[2] != 0 || last[3] != 0 {
+		t.Errorf("last frame %v, expected a disable", last)
+	}
+	// other target writes stop a ramp
+	s.Enable()
+	for _, write := range []func() error{
+		func() error { return c.SetTargets(0, []uint16{6000}) },
+		func() error { return s.Enable() },
+	} {
+		err = s.SetTarget(8000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.slewer.running {
+			t.Error("ramp still running after a target write")
+		}
+	}
+	// multiple target writes are sent without the slew limit
+	port.Reset()
+	err = c.SetTargets(0, []uint16{6000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port.AssertFrame(t, []byte{cmdSetMultipleTargets, 1, 0, lo(6000), hi(6000)})
+	// clamped targets are ramped
+	s.SetLimits(2000, 6004)
+	target, err := s.SetTargetClamped(7000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != 6004 {
+		t.Errorf("applied target %d, expected 6004", target)
+	}
+	<-s.slewer.done
+	for _, x := range []uint16{6002, 6004} {
+		port.AssertFrame(t, []byte{cmdSetTarget, 0, lo(x), hi(x)})
+	}
+	port.AssertNoFrames(t)
+}
+
+func TestRecordPositions(t *testing.T) {
+	c, port := newTestController(t)
+	s, _ := c.NewServo(0)
+	s.SetLimits(4000, 8000)
+	s.SetTrim(-100)
+	if _, err := c.RecordPositions(context.Background(), []*Servo{s}, 0); err == nil {
+		t.Error("expected an error for a zero interval")
+	}
+	// the logical position is recorded
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	port.QueueResponse(0x0c, 0x17) // 5900
+	frames, err := c.RecordPositions(ctx, []*Servo{s}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || frames[0][0] != 6000 {
+		t.Errorf("frames %v, expected [map[0:6000]]", frames)
+	}
+}
+
+// probePort is a test port for a controller with a number of channels.
+// Positions are returned for the channels, and errors are returned after
+// a position query for a non-existent channel.
+type probePort struct {
+	channels int
+	rsp      []byte
+	errs     uint16
+}
+
+func (p *probePort) Write(buf []byte) (int, error) {
+	switch {
+	case buf[0] == cmdGetPosition && int(buf[1]) < p.channels:
+		p.rsp = append(p.rsp, 0x70, 0x17)
+	case buf[0] == cmdGetPosition:
+		p.errs |= uint16(ErrSerialProtocol)
+	case buf[0] == cmdGetErrors:
+		p.rsp = append(p.rsp, byte(p.errs), byte(p.errs>>8))
+		p.errs = 0
+	}
+	return len(buf), nil
+}
+
+func (p *probePort) Read(buf []byte) (int, error) {
+	if len(p.rsp) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(buf, p.rsp)
+	p.rsp = p.rsp[n:]
+	return n, nil
+}
+
+func TestProbe(t *testing.T) {
+	port := &probePort{channels: 6}
+	n, err := Probe(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Errorf("%d channels, expected 6", n)
+	}
+	if port.errs != 0 {
+		t.Error("errors not cleared")
+	}
+	// no response
+	if _, err := Probe(sctest.NewPort()); err == nil {
+		t.Error("expected an error for no response")
+	}
+	// port read error
+	_, err = Probe(errorPort{err: errors.New("port closed")})
+	if err == nil || isTimeout(err) {
+		t.Errorf("expected a port error, got %v", err)
+	}
+}
+
+func TestFrameWriterChannels(t *testing.T) {
+	port := sctest.NewPort()
+	c, err := NewController(&Config{Port: port, Compact: true, Channels: 6, InitAction: InitNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch := uint8(4); ch < 8; ch++ {
+		c.NewServo(ch)
+	}
+	if _, err := c.NewFrameWriter(4, 4); err == nil {
+		t.Error("expected an error for channels beyond the controller channels")
+	}
+	if _, err := c.NewFrameWriter(4, 2); err != nil {
+		t.Error(err)
+	}
+}
+
+// discardPort is a serial port that discards writes.
+type discardPort struct{}
+
+func (discardPort) Read(buf []byte) (int, error)  { return 0, nil }
+func (discardPort) Write(buf []byte) (int, error) { return len(buf), nil }
+
+func newBenchController(tb testing.TB) *Controller {
+	c, err := NewController(&Config{Port: discardPort{}, Crc: true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for ch := uint8(0); ch < 6; ch++ {
+		c.NewServo(ch)
+	}
+	return c
+}
+
+func TestSetTargetAllocs(t *testing.T) {
+	c := newBenchController(t)
+	s := c.servo[0]
+	targets := []uint16{6000, 6000, 6000, 6000, 6000, 6000}
+	n := testing.AllocsPerRun(100, func() {
+		s.SetTarget(6000)
+		c.SetTargets(0, targets)
+	})
+	if n != 0 {
+		t.Errorf("%v allocations per SetTarget/SetTargets", n)
+	}
+}
+
+func BenchmarkSetTarget(b *testing.B) {
+	c := newBenchController(b)
+	s := c.servo[0]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.SetTarget(uint16(4000 + i%4000))
+	}
+}
+
+func BenchmarkSetTargets(b *testing.B) {
+	c := newBenchController(b)
+	targets := []uint16{6000, 6000, 6000, 6000, 6000, 6000}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.SetTargets(0, targets)
+	}
+}
+
+func BenchmarkFrameWriter(b *testing.B) {
+	c := newBenchController(b)
+	w, err := c.NewFrameWriter(0, 6)
+	if err != nil {
+		b.Fatal(err)
+	}
+	targets := []uint16{6000, 6000, 6000, 6000, 6000, 6000}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w.Write(targets)
+	}
+}
+
 //-----------------------------------------------------------------------------
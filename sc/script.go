@@ -0,0 +1,57 @@
+//-----------------------------------------------------------------------------
+/*
+
+Named Script Subroutines
+
+Subroutines in the Maestro script are numbered in the order they are defined.
+Naming them avoids calling the wrong subroutine by number.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import "fmt"
+
+//-----------------------------------------------------------------------------
+
+// NameSubroutine names a script subroutine number.
+func (c *Controller) NameSubroutine(name string, subroutine uint8) error {
+	if subroutine > maxSubroutine {
+		return fmt.Errorf("subroutine %d > %d", subroutine, maxSubroutine)
+	}
+	if c.subs == nil {
+		c.subs = make(map[string]uint8)
+	}
+	c.subs[name] = subroutine
+	return nil
+}
+
+// subroutine returns the subroutine number for a name.
+func (c *Controller) subroutine(name string) (uint8, error) {
+	sub, ok := c.subs[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown subroutine \"%s\"", name)
+	}
+	return sub, nil
+}
+
+// RestartNamedSubroutine restarts the servo script at a named subroutine.
+func (c *Controller) RestartNamedSubroutine(name string) error {
+	sub, err := c.subroutine(name)
+	if err != nil {
+		return err
+	}
+	return c.RestartScript(sub)
+}
+
+// RestartNamedSubroutineParms restarts the servo script at a named subroutine and parameter value.
+func (c *Controller) RestartNamedSubroutineParms(name string, val uint16) error {
+	sub, err := c.subroutine(name)
+	if err != nil {
+		return err
+	}
+	return c.RestartScriptParms(sub, val)
+}
+
+//-----------------------------------------------------------------------------
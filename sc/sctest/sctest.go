@@ -0,0 +1,117 @@
+//-----------------------------------------------------------------------------
+/*
+
+Package sctest provides a recording serial port for testing code that
+uses the servo controller package.
+
+Each Write to the port is recorded as a command frame, and reads return
+previously queued response bytes.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sctest
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+
+// Port is a recording serial port.
+type Port struct {
+	mu     sync.Mutex
+	frames [][]byte     // frames written to the port
+	rsp    bytes.Buffer // queued response bytes
+}
+
+// NewPort returns a new recording serial port.
+func NewPort() *Port {
+	return &Port{}
+}
+
+// Write records a frame written to the port.
+func (p *Port) Write(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.frames = append(p.frames, append([]byte(nil), buf...))
+	return len(buf), nil
+}
+
+// Read reads queued response bytes. It returns io.EOF if there are none (as a
+// serial port with a read timeout would).
+func (p *Port) Read(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rsp.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.rsp.Read(buf)
+}
+
+// QueueResponse queues response bytes to be read from the port.
+func (p *Port) QueueResponse(buf ...byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rsp.Write(buf)
+}
+
+// Pending returns the number of queued response bytes that have not been read.
+func (p *Port) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rsp.Len()
+}
+
+// Frames returns the recorded frames that have not been asserted.
+func (p *Port) Frames() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.frames...)
+}
+
+// Written returns the bytes of all recorded frames that have not been asserted.
+func (p *Port) Written() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return bytes.Join(p.frames, nil)
+}
+
+// Reset discards all recorded frames and queued responses.
+func (p *Port) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.frames = nil
+	p.rsp.Reset()
+}
+
+// AssertFrame checks that the oldest recorded frame is the expected frame, and then removes it.
+func (p *Port) AssertFrame(t testing.TB, expected []byte) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.frames) == 0 {
+		t.Errorf("expected frame % x, got no frame", expected)
+		return
+	}
+	frame := p.frames[0]
+	p.frames = p.frames[1:]
+	if !bytes.Equal(frame, expected) {
+		t.Errorf("expected frame % x, got % x", expected, frame)
+	}
+}
+
+// AssertNoFrames checks that there are no recorded frames.
+func (p *Port) AssertNoFrames(t testing.TB) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.frames) != 0 {
+		t.Errorf("expected no frames, got % x", bytes.Join(p.frames, nil))
+	}
+}
+
+//-----------------------------------------------------------------------------
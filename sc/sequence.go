@@ -0,0 +1,151 @@
+//-----------------------------------------------------------------------------
+/*
+
+Frame Sequence Player
+
+A sequence is a list of frames played at a fixed interval. Playback can be
+paused (the servos hold the targets of the last frame sent), resumed, and
+moved to another frame while playing or paused.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// Sequence plays a list of frames at a fixed interval.
+type Sequence struct {
+	ctrl     *Controller
+	frames   []Frame       // frames to play
+	interval time.Duration // time between frames
+	mu       sync.Mutex    // protects the playback state
+	index    int           // next frame to send
+	paused   bool          // is playback paused?
+	seeked   bool          // has the frame been changed while paused?
+	wake     chan struct{} // wakes a paused player
+}
+
+// NewSequence returns a sequence player for a list of frames sent at an interval.
+func (c *Controller) NewSequence(frames []Frame, interval time.Duration) (*Sequence, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("no frames in sequence")
+	}
+	if interval <= 0 {
+		return nil, errors.New("interval must be > 0")
+	}
+	return &Sequence{
+		ctrl:     c,
+		frames:   frames,
+		interval: interval,
+		wake:     make(chan struct{}, 1),
+	}, nil
+}
+
+// signal wakes a paused player.
+func (q *Sequence) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Play sends the frames of the sequence, starting at the current frame. It returns
+// nil after the last frame has been sent, the context error if the context is canceled,
+// or the first write error. While paused no frames are sent. Only call Play from one
+// goroutine at a time.
+func (q *Sequence) Play(ctx context.Context) error {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		if q.paused && !q.seeked {
+			q.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-q.wake:
+			}
+			// restart the frame interval after a pause
+			ticker.Reset(q.interval)
+			continue
+		}
+		if q.index >= len(q.frames) {
+			q.mu.Unlock()
+			return nil
+		}
+		i := q.index
+		q.index++
+		q.seeked = false
+		paused := q.paused
+		q.mu.Unlock()
+		err := q.ctrl.writeFrame(q.frames[i])
+		if err != nil {
+			return err
+		}
+		if paused {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pause stops sending frames. The servos hold the targets of the last frame sent
+// (they are not disabled).
+func (q *Sequence) Pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = true
+}
+
+// Resume continues sending frames after a pause.
+func (q *Sequence) Resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = false
+	q.signal()
+}
+
+// Paused returns true if playback is paused.
+func (q *Sequence) Paused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// Seek sets the next frame to be sent. If playback is paused the frame is sent
+// immediately and the servos hold it until playback is resumed.
+func (q *Sequence) Seek(frame int) error {
+	if frame < 0 || frame >= len(q.frames) {
+		return fmt.Errorf("bad frame %d (sequence has %d frames)", frame, len(q.frames))
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.index = frame
+	if q.paused {
+		q.seeked = true
+		q.signal()
+	}
+	return nil
+}
+
+// Frame returns the index of the next frame to be sent.
+func (q *Sequence) Frame() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.index
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,144 @@
+//-----------------------------------------------------------------------------
+/*
+
+Host Slew Rate Limit
+
+The Maestro speed limit has a coarse unit (0.25us/10ms), and is set per
+channel on each controller. A host-side slew rate limit ramps the target in
+a goroutine so the commanded position never changes faster than the limit.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// slewer is the state of a slew rate limited target ramp.
+type slewer struct {
+	mu      sync.Mutex
+	goal    uint16        // ramp goal
+	running bool          // is the ramp goroutine running?
+	err     error         // last write error
+	stop    chan struct{} // closed to stop the ramp
+	done    chan struct{} // closed when the ramp has stopped
+}
+
+// SetMaxSlew sets the maximum rate of change (ticks/second) of the servo target (0 is no limit).
+// With a limit SetTarget (and SetTargetClamped) returns immediately and a goroutine ramps
+// the target to the new value, sending a target every update interval (20ms). A new target
+// during a ramp changes the ramp goal. Any other target write for the servo (e.g. Disable,
+// Enable, or multiple target writes such as SetTargets, FrameWriter.Write and the frame
+// functions) stops the ramp and is sent immediately without the slew limit.
+// While ramping other target writes for this servo should not be made concurrently.
+func (s *Servo) SetMaxSlew(ticksPerSecond float64) error {
+	if ticksPerSecond < 0 {
+		return errors.New("slew rate must be >= 0")
+	}
+	s.stopSlew()
+	s.slew = ticksPerSecond
+	if s.slew != 0 && s.slewer == nil {
+		s.slewer = &slewer{}
+	}
+	return nil
+}
+
+// MaxSlew returns the maximum rate of change (ticks/second) of the servo target (0 is no limit).
+func (s *Servo) MaxSlew() float64 {
+	return s.slew
+}
+
+// slewTo starts (or changes the goal of) a target ramp. A disable or a target without a
+// previous target to ramp from is written immediately. The last ramp write error is returned.
+func (s *Servo) slewTo(target uint16) error {
+	if target == 0 {
+		// turn off immediately (stopping any ramp)
+		return s.writeTarget(0)
+	}
+	sl := s.slewer
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	err := sl.err
+	sl.err = nil
+	if sl.running {
+		sl.goal = target
+		return err
+	}
+	if !s.sent || s.target == 0 {
+		werr := s.sendTarget(target)
+		if err == nil {
+			err = werr
+		}
+		return err
+	}
+	if s.inDeadband(target) {
+		return err
+	}
+	sl.goal = target
+	sl.running = true
+	sl.stop = make(chan struct{})
+	sl.done = make(chan struct{})
+	go s.slewWriter(sl, float64(s.target), s.slew*updateInterval.Seconds())
+	return err
+}
+
+// stopSlew stops any running target ramp.
+func (s *Servo) stopSlew() {
+	sl := s.slewer
+	if sl == nil {
+		return
+	}
+	sl.mu.Lock()
+	running, stop, done := sl.running, sl.stop, sl.done
+	sl.mu.Unlock()
+	if running {
+		close(stop)
+		<-done
+	}
+}
+
+// slewWriter is the target ramp goroutine.
+func (s *Servo) slewWriter(sl *slewer, cur, step float64) {
+	defer close(sl.done)
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sl.stop:
+			sl.mu.Lock()
+			sl.running = false
+			sl.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+		sl.mu.Lock()
+		goal := sl.goal
+		sl.mu.Unlock()
+		// move towards the goal
+		delta := float64(goal) - cur
+		if math.Abs(delta) <= step {
+			cur = float64(goal)
+		} else {
+			cur += math.Copysign(step, delta)
+		}
+		t := uint16(math.Round(cur))
+		err := s.sendTarget(t)
+		sl.mu.Lock()
+		if err != nil || t == sl.goal {
+			sl.err = err
+			sl.running = false
+			sl.mu.Unlock()
+			return
+		}
+		sl.mu.Unlock()
+	}
+}
+
+//-----------------------------------------------------------------------------
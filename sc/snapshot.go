@@ -0,0 +1,81 @@
+//-----------------------------------------------------------------------------
+/*
+
+Controller Snapshot
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// Snapshot is the state of a controller at a point in time.
+type Snapshot struct {
+	Time     time.Time // time the snapshot was taken
+	Position Frame     // position of each configured servo
+	Enabled  []uint8   // channels with enabled servos
+	Moving   bool      // moving state for all servos
+	Errors   uint16    // error bitmap
+}
+
+// Snapshot returns the position of each configured servo, the moving state
+// and the error bitmap. The serial link is held for the whole snapshot, so commands
+// from other goroutines can't change the state between the reads.
+// Note: reading the error bitmap clears the controller errors.
+func (c *Controller) Snapshot() (*Snapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap := &Snapshot{
+		Time:     time.Now(),
+		Position: make(Frame),
+	}
+	var buf [2]byte
+	read16 := func(cmd []byte) (uint16, error) {
+		_, err := c.queryLocked(cmd, buf[:])
+		if err != nil {
+			return 0, err
+		}
+		return decode8(buf[0], buf[1]), nil
+	}
+	for _, s := range c.servo {
+		if s == nil {
+			continue
+		}
+		cmd := s.cmdPreamble(cmdGetPosition)
+		pos, err := s.checkPosition(func() (uint16, error) {
+			return read16(cmd)
+		})
+		if err != nil {
+			return nil, err
+		}
+		snap.Position[s.channel] = pos
+		if s.Enabled() {
+			snap.Enabled = append(snap.Enabled, s.channel)
+		}
+	}
+	_, err := c.queryLocked(c.cmdPreamble(cmdGetMovingState), buf[:1])
+	if err != nil {
+		return nil, err
+	}
+	snap.Moving = decodeBool(buf[0])
+	c.moving = snap.Moving
+	c.movingTime = time.Now()
+	snap.Errors, err = read16(c.cmdPreamble(cmdGetErrors))
+	if errors.Is(err, errRspCrc) {
+		// retry once on a bad response crc (see GetErrors)
+		snap.Errors, err = read16(c.cmdPreamble(cmdGetErrors))
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.noteErrors(snap.Errors)
+	return snap, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,163 @@
+//-----------------------------------------------------------------------------
+/*
+
+Controller State
+
+The controller and servo configuration can be saved as JSON (e.g. after an
+interactive calibration) and later restored.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+
+// ControllerState is the saved state of a controller.
+type ControllerState struct {
+	Device  uint8        `json:"device"`   // device number
+	Compact bool         `json:"compact"`  // compact protocol
+	Crc     bool         `json:"crc"`      // outgoing crc
+	CrcRead bool         `json:"crc_read"` // response crc
+	Servos  []ServoState `json:"servos"`   // configured servos
+}
+
+// ServoState is the saved state of a servo.
+type ServoState struct {
+	Channel  uint8    `json:"channel"`          // servo channel number
+	Min      uint16   `json:"min"`              // minimum target position
+	Max      uint16   `json:"max"`              // maximum target position
+	Clamp    bool     `json:"clamp"`            // clamp out-of-range target values
+	Deadband uint16   `json:"deadband"`         // target deadband
+	Rounding Rounding `json:"rounding"`         // rounding mode for target conversions
+	Trim     int16    `json:"trim,omitempty"`   // target trim offset
+	Home     *uint16  `json:"home,omitempty"`   // host-side home position
+	Target   *uint16  `json:"target,omitempty"` // last target value sent
+	// last speed and acceleration limits set
+	Speed        *Speed        `json:"speed,omitempty"`
+	Acceleration *Acceleration `json:"acceleration,omitempty"`
+	// physical unit calibration
+	Calibration *Calibration `json:"calibration,omitempty"`
+}
+
+// state returns the saved state of the servo.
+func (s *Servo) state() ServoState {
+	ss := ServoState{
+		Channel:  s.channel,
+		Min:      s.min,
+		Max:      s.max,
+		Clamp:    s.clamp,
+		Deadband: s.deadband,
+		Rounding: s.rounding,
+		Trim:     s.trim,
+	}
+	if s.homeSet {
+		home := s.home
+		ss.Home = &home
+	}
+	if s.sent {
+		target := s.target
+		ss.Target = &target
+	}
+	if s.speedSet {
+		speed := s.speed
+		ss.Speed = &speed
+	}
+	if s.accelSet {
+		accel := s.accel
+		ss.Acceleration = &accel
+	}
+	ss.Calibration = s.Calibration()
+	return ss
+}
+
+// stateDevice returns the device number (not used by the compact protocol).
+func (c *Controller) stateDevice() uint8 {
+	if c.compact {
+		return 0
+	}
+	return c.device
+}
+
+// State returns the saved state of the controller.
+func (c *Controller) State() *ControllerState {
+	cs := &ControllerState{
+		Device:  c.stateDevice(),
+		Compact: c.compact,
+		Crc:     c.crc,
+		CrcRead: c.crcRead,
+		Servos:  []ServoState{},
+	}
+	for _, s := range c.servo {
+		if s != nil {
+			cs.Servos = append(cs.Servos, s.state())
+		}
+	}
+	return cs
+}
+
+// MarshalState returns the controller state as JSON.
+func (c *Controller) MarshalState() ([]byte, error) {
+	return json.MarshalIndent(c.State(), "", "  ")
+}
+
+// SetState configures the controller servos from a saved state.
+// The serial configuration of the controller is not changed, and the last
+// target values are not sent to the servos. Saved speed and acceleration
+// limits are sent to the controller.
+func (c *Controller) SetState(cs *ControllerState) error {
+	for _, ss := range cs.Servos {
+		s, err := c.NewServo(ss.Channel)
+		if err != nil {
+			return err
+		}
+		err = s.SetLimits(ss.Min, ss.Max)
+		if err != nil {
+			return fmt.Errorf("channel %d: %s", ss.Channel, err)
+		}
+		s.SetClamp(ss.Clamp)
+		s.SetDeadband(ss.Deadband)
+		s.SetRounding(ss.Rounding)
+		s.SetTrim(ss.Trim)
+		err = s.SetCalibration(ss.Calibration)
+		if err != nil {
+			return fmt.Errorf("channel %d: calibration %s", ss.Channel, err)
+		}
+		if ss.Home != nil {
+			err = s.SetHome(*ss.Home)
+			if err != nil {
+				return fmt.Errorf("channel %d: home %s", ss.Channel, err)
+			}
+		}
+		if ss.Speed != nil {
+			err = s.SetSpeed(*ss.Speed)
+			if err != nil {
+				return fmt.Errorf("channel %d: speed %s", ss.Channel, err)
+			}
+		}
+		if ss.Acceleration != nil {
+			err = s.SetAcceleration(*ss.Acceleration)
+			if err != nil {
+				return fmt.Errorf("channel %d: acceleration %s", ss.Channel, err)
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalState configures the controller servos from a JSON saved state.
+func (c *Controller) UnmarshalState(data []byte) error {
+	cs := &ControllerState{}
+	err := json.Unmarshal(data, cs)
+	if err != nil {
+		return err
+	}
+	return c.SetState(cs)
+}
+
+//-----------------------------------------------------------------------------
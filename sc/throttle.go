@@ -0,0 +1,54 @@
+//-----------------------------------------------------------------------------
+/*
+
+Command Rate Limit
+
+A token bucket limits the rate of command writes to the serial port. This is
+a backstop against saturating a slow serial bridge, regardless of how often
+the higher level functions write commands.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import "time"
+
+//-----------------------------------------------------------------------------
+
+// number of commands that may be written back-to-back
+const cmdBurst = 8
+
+// tokenBucket limits the command rate.
+type tokenBucket struct {
+	rate   float64   // tokens per second
+	tokens float64   // available tokens
+	last   time.Time // time of the last token update
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		tokens: cmdBurst,
+		last:   time.Now(),
+	}
+}
+
+// wait takes a token from the bucket, waiting for one if none are available.
+func (b *tokenBucket) wait() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > cmdBurst {
+		b.tokens = cmdBurst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return
+	}
+	time.Sleep(time.Duration((1 - b.tokens) / b.rate * float64(time.Second)))
+	b.tokens = 0
+	b.last = time.Now()
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,136 @@
+//-----------------------------------------------------------------------------
+/*
+
+Serial Transport
+
+A transport writes command frames to and reads responses from a device.
+An io.ReadWriter (e.g. a serial port) is adapted to a transport by default.
+Other transports (e.g. USB, RS-485 with echo cancellation, TCP) may implement
+the Transport interface directly.
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// Transport carries command frames and responses.
+type Transport interface {
+	// WriteFrame writes a command frame.
+	WriteFrame(frame []byte) error
+	// ReadN reads n response bytes. If the read times out the bytes read so
+	// far are returned with a nil or timeout error.
+	ReadN(n int) ([]byte, error)
+}
+
+// Flusher is implemented by transports that can discard pending input.
+type Flusher interface {
+	Flush() error
+}
+
+// Deadliner is implemented by transports with read deadlines.
+// A zero time clears the deadline.
+type Deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// ReadDeadliner is implemented by ports that support read deadlines (e.g. net.Conn).
+// Ports without read deadlines (e.g. tarm/serial) use their own read timeout.
+type ReadDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+//-----------------------------------------------------------------------------
+
+// portTransport adapts an io.ReadWriter to a transport.
+type portTransport struct {
+	rw io.ReadWriter
+}
+
+// deadlinePortTransport adapts an io.ReadWriter with read deadlines to a transport.
+type deadlinePortTransport struct {
+	portTransport
+	d ReadDeadliner
+}
+
+// NewTransport returns a transport for an io.ReadWriter. If the port has
+// a SetReadDeadline method (e.g. net.Conn) the transport is a Deadliner.
+func NewTransport(rw io.ReadWriter) Transport {
+	if d, ok := rw.(ReadDeadliner); ok {
+		return &deadlinePortTransport{portTransport{rw}, d}
+	}
+	return &portTransport{rw}
+}
+
+// WriteFrame writes a command frame to the port.
+func (t *portTransport) WriteFrame(frame []byte) error {
+	n, err := t.rw.Write(frame)
+	if err != nil {
+		return err
+	}
+	if n != len(frame) {
+		return errors.New("short write")
+	}
+	return nil
+}
+
+// ReadN reads n bytes from the port.
+// Reading stops on a read error or when a read returns no data.
+func (t *portTransport) ReadN(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	got := 0
+	for got < n {
+		k, err := t.rw.Read(buf[got:])
+		got += k
+		if err != nil {
+			return buf[:got], err
+		}
+		if k == 0 {
+			break
+		}
+	}
+	return buf[:got], nil
+}
+
+// Flush reads and discards pending input. The port read timeout limits the wait for input.
+func (t *portTransport) Flush() error {
+	var buf [64]byte
+	for {
+		n, err := t.rw.Read(buf[:])
+		if err != nil || n == 0 {
+			return nil
+		}
+	}
+}
+
+// Close closes the port (if it is an io.Closer).
+func (t *portTransport) Close() error {
+	if c, ok := t.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SetDeadline sets the port read deadline.
+func (t *deadlinePortTransport) SetDeadline(deadline time.Time) error {
+	return t.d.SetReadDeadline(deadline)
+}
+
+// Flush reads and discards pending input, waiting for up to flushTimeout for input.
+func (t *deadlinePortTransport) Flush() error {
+	err := t.d.SetReadDeadline(time.Now().Add(flushTimeout))
+	if err != nil {
+		return err
+	}
+	defer t.d.SetReadDeadline(time.Time{})
+	return t.portTransport.Flush()
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,113 @@
+//-----------------------------------------------------------------------------
+/*
+
+Servo Units
+
+Target values are in units of 0.25us of servo control pulse width.
+Speed values are in units of 0.25us/10ms.
+Acceleration values are in units of 0.25us/10ms/80ms.
+
+See: https://www.pololu.com/docs/pdf/0J40/maestro.pdf
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import "math"
+
+//-----------------------------------------------------------------------------
+
+// Speed is a servo speed limit in units of 0.25us/10ms (0 is no limit).
+type Speed uint16
+
+// Acceleration is a servo acceleration limit in units of 0.25us/10ms/80ms (0 is no limit).
+type Acceleration uint16
+
+// speed unit in us/s
+const speedUnit = (1.0 / uSec) / 10e-3
+
+// acceleration unit in us/s/s
+const accelerationUnit = speedUnit / 80e-3
+
+// toUnits converts a physical value to a (rounded) number of units.
+func toUnits(v, unit float64) uint16 {
+	x := math.Round(v / unit)
+	if x < 0 {
+		return 0
+	}
+	if x > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return uint16(x)
+}
+
+// SpeedFromMicrosPerSec returns the speed for a pulse width change rate in us/s.
+func SpeedFromMicrosPerSec(v float64) Speed {
+	return Speed(toUnits(v, speedUnit))
+}
+
+// MicrosPerSec returns the speed as a pulse width change rate in us/s.
+func (s Speed) MicrosPerSec() float64 {
+	return float64(s) * speedUnit
+}
+
+// AccelerationFromMicrosPerSecSq returns the acceleration for a pulse width change rate in us/s/s.
+func AccelerationFromMicrosPerSecSq(v float64) Acceleration {
+	return Acceleration(toUnits(v, accelerationUnit))
+}
+
+// MicrosPerSecSq returns the acceleration as a pulse width change rate in us/s/s.
+func (a Acceleration) MicrosPerSecSq() float64 {
+	return float64(a) * accelerationUnit
+}
+
+// Rounding is the rounding mode used when converting to target ticks.
+type Rounding int
+
+// Rounding modes.
+const (
+	RoundNearest Rounding = iota // round to the nearest tick (default)
+	RoundFloor                   // round down
+	RoundCeil                    // round up
+)
+
+// round rounds a value to an integer using the rounding mode.
+func (r Rounding) round(x float64) float64 {
+	switch r {
+	case RoundFloor:
+		return math.Floor(x)
+	case RoundCeil:
+		return math.Ceil(x)
+	}
+	return math.Round(x)
+}
+
+// toTicks converts a real valued target to an integer number of ticks.
+func (r Rounding) toTicks(x float64) uint16 {
+	x = r.round(x)
+	if x < 0 {
+		return 0
+	}
+	if x > maxTarget {
+		return maxTarget
+	}
+	return uint16(x)
+}
+
+// SetRounding sets the rounding mode used when converting to target ticks.
+func (s *Servo) SetRounding(r Rounding) {
+	s.rounding = r
+}
+
+// MicrosToTarget converts a servo control pulse width in microseconds to a target value.
+func (s *Servo) MicrosToTarget(us float64) uint16 {
+	return s.rounding.toTicks(us * uSec)
+}
+
+// SetTargetMicros sets the servo target as a control pulse width in microseconds.
+func (s *Servo) SetTargetMicros(us float64) error {
+	return s.SetTarget(s.MicrosToTarget(us))
+}
+
+//-----------------------------------------------------------------------------
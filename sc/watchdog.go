@@ -0,0 +1,134 @@
+//-----------------------------------------------------------------------------
+/*
+
+Command Watchdog
+
+If the watchdog is running and no command is written to the controller within
+the timeout (e.g. the control loop has crashed) the watchdog handler is called.
+The default handler turns off all servos.
+
+The handler runs on the watchdog goroutine. The default handler writes the
+turn off commands directly (serialized with other commands by the serial link)
+without changing the servo state, and servos turned off by the watchdog are
+then reported as disabled (see Servo.Enabled).
+
+*/
+//-----------------------------------------------------------------------------
+
+package sc
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// watchdog is the state of the command watchdog.
+type watchdog struct {
+	mu      sync.Mutex
+	timer   *time.Timer   // watchdog timer (nil if stopped)
+	timeout time.Duration // watchdog timeout
+	handler func()        // called on timeout (nil turns off all servos)
+	servos  uint32        // bitmap of the configured servo channels (atomic)
+	offs    uint32        // number of times the servos have been turned off (atomic)
+}
+
+// addServo adds a servo channel to the servos turned off on timeout.
+func (wd *watchdog) addServo(channel uint8) {
+	for {
+		old := atomic.LoadUint32(&wd.servos)
+		if atomic.CompareAndSwapUint32(&wd.servos, old, old|1<<channel) {
+			return
+		}
+	}
+}
+
+// SetWatchdogHandler sets the function called when the watchdog times out.
+// A nil handler (the default) turns off all servos. The handler is called from
+// the watchdog goroutine, so it must synchronize any servo state it uses with
+// the other goroutines using the controller.
+func (c *Controller) SetWatchdogHandler(fn func()) {
+	c.wd.mu.Lock()
+	defer c.wd.mu.Unlock()
+	c.wd.handler = fn
+}
+
+// StartWatchdog starts the watchdog. If no command is written to the controller
+// within the timeout the watchdog handler is called from the watchdog goroutine.
+// The handler is called again after each further timeout with no commands written.
+func (c *Controller) StartWatchdog(timeout time.Duration) error {
+	if timeout <= 0 {
+		return errors.New("timeout must be > 0")
+	}
+	c.wd.mu.Lock()
+	defer c.wd.mu.Unlock()
+	if c.wd.timer != nil {
+		return errors.New("watchdog is already running")
+	}
+	c.wd.timeout = timeout
+	c.wd.timer = time.AfterFunc(timeout, c.watchdogExpired)
+	return nil
+}
+
+// StopWatchdog stops the watchdog.
+func (c *Controller) StopWatchdog() {
+	c.wd.mu.Lock()
+	defer c.wd.mu.Unlock()
+	if c.wd.timer != nil {
+		c.wd.timer.Stop()
+		c.wd.timer = nil
+	}
+}
+
+// kickWatchdog restarts the watchdog timeout.
+func (c *Controller) kickWatchdog() {
+	c.wd.mu.Lock()
+	defer c.wd.mu.Unlock()
+	if c.wd.timer != nil {
+		c.wd.timer.Reset(c.wd.timeout)
+	}
+}
+
+// watchdogExpired calls the watchdog handler and restarts the watchdog timeout.
+func (c *Controller) watchdogExpired() {
+	c.wd.mu.Lock()
+	handler := c.wd.handler
+	running := c.wd.timer != nil
+	c.wd.mu.Unlock()
+	if !running {
+		return
+	}
+	if handler != nil {
+		handler()
+	} else {
+		c.watchdogOff()
+	}
+	c.kickWatchdog()
+}
+
+// watchdogOff turns off all the configured servos without changing the servo state.
+func (c *Controller) watchdogOff() {
+	servos := atomic.LoadUint32(&c.wd.servos)
+	cmds := [][]byte{}
+	for ch := uint8(0); ch < maxServos; ch++ {
+		if servos&(1<<ch) != 0 {
+			cmds = append(cmds, append(c.cmdPreamble(cmdSetTarget), ch, 0, 0))
+		}
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	// (a write error may leave the servos on or off, so assume off)
+	c.cmdWriteN(cmds)
+	atomic.AddUint32(&c.wd.offs, 1)
+}
+
+// offByWatchdog returns true if the watchdog has turned off the servo since the last target was sent.
+func (s *Servo) offByWatchdog() bool {
+	return s.sent && atomic.LoadUint32(&s.ctrl.wd.offs) != s.wdOffs
+}
+
+//-----------------------------------------------------------------------------